@@ -2,6 +2,7 @@ package easytcp
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net"
@@ -85,6 +86,32 @@ func Benchmark_DefaultPacker_Unpack(b *testing.B) {
 	}
 }
 
+func Benchmark_Router_handleRequest(b *testing.B) {
+	mw := func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) { next(ctx) }
+	}
+	rt := newRouter()
+	rt.registerMiddleware(mw, mw)
+	for i := 0; i < 100; i++ {
+		rt.register(i, nilHandler, mw)
+		rt.register(100000+i, nilHandler, mw)
+		rt.register(fmt.Sprintf("id-%d", i), nilHandler, mw)
+	}
+	bench := func(id interface{}) func(b *testing.B) {
+		return func(b *testing.B) {
+			ctx := &routeContext{reqMsg: NewMessage(id, nil)}
+			beforeBench(b)
+			for i := 0; i < b.N; i++ {
+				rt.handleRequest(ctx)
+			}
+		}
+	}
+	b.Run("small int id", bench(50))
+	b.Run("large int id", bench(100050))
+	b.Run("string id", bench("id-50"))
+	b.Run("not found", bench(-1))
+}
+
 func beforeBench(b *testing.B) {
 	_log = &mutedLogger{}
 	b.ReportAllocs()
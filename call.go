@@ -0,0 +1,101 @@
+package easytcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cast"
+	"sync/atomic"
+)
+
+// MessageSeqKey is the Message storage key of the sequence ID of a request, e.g. the one of Session.Call.
+// To support Session.Call, the Packer should write the sequence ID into the packet in Pack,
+// and store it back with this key in Unpack, e.g. LengthFieldPacker with a HeaderField of this key.
+const MessageSeqKey = "easytcp.seq"
+
+// MessageReplyKey is the Message storage key of the sequence ID of the request a reply is for.
+// The client should reply to the request of Session.Call with its sequence ID stored with this key,
+// which the Packer should carry like MessageSeqKey.
+// It's apart from MessageSeqKey, so that the client's own requests are never taken as replies.
+const MessageReplyKey = "easytcp.reply"
+
+// ErrSessionClosed is returned when session's closed.
+var ErrSessionClosed = fmt.Errorf("session closed")
+
+// ErrCallInReader is returned when Session.Call is called in the goroutine reading inbound messages,
+// e.g. by a handler when the router's not async, which would block reading the reply.
+var ErrCallInReader = fmt.Errorf("call in the goroutine reading inbound messages")
+
+// Call sends a request message to the client, and waits for the reply.
+// req is encoded with the session's codec, and reply data is decoded into resp, if resp is not nil.
+// Each request message carries a new sequence ID in storage with MessageSeqKey.
+// The inbound message with the sequence ID stored with MessageReplyKey is treated as the reply, and won't be routed.
+// Returns ctx.Err() if ctx is done before the reply comes, or ErrSessionClosed if the session's closed.
+// Returns ErrCallInReader without sending if it's called in the goroutine reading inbound messages.
+func (s *session) Call(ctx context.Context, id, req, resp interface{}) error {
+	if s.codec == nil {
+		return fmt.Errorf("codec is nil")
+	}
+	if id := atomic.LoadUint64(&s.readerID); id != 0 && id == goroutineID() {
+		return ErrCallInReader
+	}
+	data, err := s.codec.Encode(req)
+	if err != nil {
+		return err
+	}
+	seq := atomic.AddUint32(&s.seq, 1)
+	reqMsg := NewMessage(id, data)
+	reqMsg.Set(MessageSeqKey, seq)
+
+	replyC := make(chan *Message, 1)
+	s.callsMu.Lock()
+	if s.calls == nil {
+		s.calls = make(map[uint32]chan *Message)
+	}
+	s.calls[seq] = replyC
+	s.callsMu.Unlock()
+	defer func() {
+		s.callsMu.Lock()
+		delete(s.calls, seq)
+		s.callsMu.Unlock()
+	}()
+
+	if !s.AllocateContext().WithContext(ctx).SetResponseMessage(reqMsg).Send() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrSessionClosed
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.closedC:
+		return ErrSessionClosed
+	case replyMsg := <-replyC:
+		if resp == nil {
+			return nil
+		}
+		return s.codec.Decode(replyMsg.Data(), resp)
+	}
+}
+
+// deliverReply passes msg to the Call waiting for the sequence ID it replies to.
+// Returns false if msg is not a reply.
+func (s *session) deliverReply(msg *Message) bool {
+	v, has := msg.Get(MessageReplyKey)
+	if !has {
+		return false
+	}
+	seq, err := cast.ToUint32E(v)
+	if err != nil {
+		return false
+	}
+	s.callsMu.Lock()
+	replyC, has := s.calls[seq]
+	delete(s.calls, seq)
+	s.callsMu.Unlock()
+	if !has {
+		return false
+	}
+	replyC <- msg
+	return true
+}
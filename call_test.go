@@ -0,0 +1,191 @@
+package easytcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/DarthPestilane/easytcp/internal/mock"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_session_Call(t *testing.T) {
+	type Ping struct{ Text string }
+
+	t.Run("when session hasn't codec", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{})
+		assert.Error(t, sess.Call(context.Background(), 1, "ping", nil))
+	})
+	t.Run("when encode failed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		codec := mock.NewMockCodec(ctrl)
+		codec.EXPECT().Encode(gomock.Any()).Return(nil, fmt.Errorf("some err"))
+		sess := newSession(nil, &sessionOption{Codec: codec})
+		assert.Error(t, sess.Call(context.Background(), 1, "ping", nil))
+	})
+	t.Run("when session is closed", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}})
+		sess.Close()
+		assert.ErrorIs(t, sess.Call(context.Background(), 1, "ping", nil), ErrSessionClosed)
+	})
+	t.Run("when session is closed before reply", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}, respQueueSize: 10})
+		go func() {
+			<-sess.respStream
+			sess.Close()
+		}()
+		assert.ErrorIs(t, sess.Call(context.Background(), 1, "ping", nil), ErrSessionClosed)
+	})
+	t.Run("when timeout", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}, respQueueSize: 10})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, sess.Call(ctx, 1, "ping", nil), context.DeadlineExceeded)
+		assert.Empty(t, sess.calls)
+
+		// request can't be sent
+		sess = newSession(nil, &sessionOption{Codec: &JsonCodec{}})
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		assert.ErrorIs(t, sess.Call(ctx, 1, "ping", nil), context.DeadlineExceeded)
+	})
+	t.Run("when reply comes", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}, respQueueSize: 10})
+		r := newRouter()
+		r.register(2, func(ctx Context) { assert.Fail(t, "reply should not be routed") })
+		go func() {
+			ctx := <-sess.respStream
+			reqMsg := ctx.Response()
+			assert.EqualValues(t, 1, reqMsg.ID())
+			assert.Equal(t, `{"Text":"ping"}`, string(reqMsg.Data()))
+			seq := reqMsg.MustGet(MessageSeqKey)
+
+			replyMsg := NewMessage(2, []byte(`{"Text":"pong"}`))
+			replyMsg.Set(MessageReplyKey, int(seq.(uint32))) // packer may unpack it in another type
+			sess.handleInbound(r, replyMsg)
+		}()
+		var resp Ping
+		assert.NoError(t, sess.Call(context.Background(), 1, &Ping{Text: "ping"}, &resp))
+		assert.Equal(t, "pong", resp.Text)
+	})
+	t.Run("when resp is nil", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}, respQueueSize: 10})
+		go func() {
+			ctx := <-sess.respStream
+			replyMsg := NewMessage(2, nil)
+			replyMsg.Set(MessageReplyKey, ctx.Response().MustGet(MessageSeqKey))
+			assert.True(t, sess.deliverReply(replyMsg))
+		}()
+		assert.NoError(t, sess.Call(context.Background(), 1, "ping", nil))
+	})
+}
+
+func Test_session_deliverReply(t *testing.T) {
+	sess := newSession(nil, &sessionOption{})
+	assert.False(t, sess.deliverReply(NewMessage(1, nil)))
+
+	msg := NewMessage(1, nil)
+	msg.Set(MessageReplyKey, "invalid")
+	assert.False(t, sess.deliverReply(msg))
+
+	msg.Set(MessageReplyKey, 1) // no pending call
+	assert.False(t, sess.deliverReply(msg))
+
+	// a request of the client with the same sequence ID as a pending call
+	sess.calls = map[uint32]chan *Message{1: make(chan *Message, 1)}
+	msg = NewMessage(1, nil)
+	msg.Set(MessageSeqKey, 1)
+	assert.False(t, sess.deliverReply(msg))
+	assert.Len(t, sess.calls, 1)
+}
+
+func TestServer_Call(t *testing.T) {
+	newServer := func(t *testing.T, async bool) (*Server, net.Conn, chan Session) {
+		packer, err := NewLengthFieldPacker(LengthFieldPackerOption{
+			LengthFieldSize: 4,
+			IDFieldOffset:   4,
+			IDFieldSize:     4,
+			HeaderFields: []HeaderField{
+				{Key: MessageSeqKey, Offset: 8, Size: 4},
+				{Key: MessageReplyKey, Offset: 12, Size: 4},
+			},
+		})
+		require.NoError(t, err)
+		server := NewServer(&ServerOption{Packer: packer, Codec: &JsonCodec{}, AsyncRouter: async, DoNotPrintRoutes: true})
+		server.AddRoute(1, func(ctx Context) {
+			var resp string
+			if err := ctx.Session().Call(ctx, 10, "ping", &resp); err != nil {
+				resp = err.Error()
+			}
+			ctx.SetResponseMessage(NewMessage(2, []byte(resp)))
+		})
+		server.AddRoute(3, func(ctx Context) {
+			ctx.SetResponseMessage(NewMessage(4, nil))
+		})
+		sessC := make(chan Session, 1)
+		server.OnSessionCreate = func(sess Session) { sessC <- sess }
+		go func() {
+			assert.ErrorIs(t, server.Run("localhost:0"), ErrServerStopped)
+		}()
+		<-server.acceptingC
+		cli, err := net.Dial("tcp", server.Listener.Addr().String())
+		require.NoError(t, err)
+		reqBytes, err := packer.Pack(NewMessage(1, nil))
+		require.NoError(t, err)
+		_, err = cli.Write(reqBytes)
+		require.NoError(t, err)
+		return server, cli, sessC
+	}
+
+	t.Run("when called by handler in reading goroutine", func(t *testing.T) {
+		server, cli, sessC := newServer(t, false)
+		defer func() { assert.NoError(t, server.Stop()) }()
+
+		respMsg, err := server.Packer.Unpack(cli)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, respMsg.ID())
+		assert.Equal(t, ErrCallInReader.Error(), string(respMsg.Data()))
+		assert.NoError(t, cli.Close())
+		<-(<-sessC).AfterCloseHook()
+	})
+	t.Run("when called by async handler", func(t *testing.T) {
+		server, cli, sessC := newServer(t, true)
+		defer func() { assert.NoError(t, server.Stop()) }()
+
+		reqMsg, err := server.Packer.Unpack(cli)
+		require.NoError(t, err)
+		assert.EqualValues(t, 10, reqMsg.ID())
+		seq, has := reqMsg.Get(MessageSeqKey) // carried on the wire
+		require.True(t, has)
+
+		// a request of the client with the same sequence ID is routed as usual
+		clientReq := NewMessage(3, nil)
+		clientReq.Set(MessageSeqKey, seq)
+		clientReqBytes, err := server.Packer.Pack(clientReq)
+		require.NoError(t, err)
+		_, err = cli.Write(clientReqBytes)
+		require.NoError(t, err)
+		respMsg, err := server.Packer.Unpack(cli)
+		require.NoError(t, err)
+		assert.EqualValues(t, 4, respMsg.ID())
+
+		replyMsg := NewMessage(11, []byte(`"pong"`))
+		replyMsg.Set(MessageReplyKey, seq)
+		replyBytes, err := server.Packer.Pack(replyMsg)
+		require.NoError(t, err)
+		_, err = cli.Write(replyBytes)
+		require.NoError(t, err)
+
+		respMsg, err = server.Packer.Unpack(cli)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, respMsg.ID())
+		assert.Equal(t, "pong", string(respMsg.Data()))
+		assert.NoError(t, cli.Close())
+		<-(<-sessC).AfterCloseHook()
+	})
+}
@@ -0,0 +1,139 @@
+package easytcp
+
+import (
+	"fmt"
+	"github.com/spf13/cast"
+	"sync/atomic"
+	"time"
+)
+
+// MessageDeliveryKey is the Message storage key of the delivery ID of Session.SendReliable.
+// To support reliable delivery, the Packer should write the delivery ID into the packet in Pack,
+// and store it back with this key in Unpack, e.g. LengthFieldPacker with a HeaderField of this key.
+// The client should ack with the same delivery ID.
+const MessageDeliveryKey = "easytcp.delivery"
+
+// ErrDeliveryFailed is passed to the OnDeliveryFailed hook when a message's not acked after max retries.
+var ErrDeliveryFailed = fmt.Errorf("delivery failed after max retries")
+
+// delivery is a message waiting to be acked.
+type delivery struct {
+	msg     *Message
+	retries int       // number of retransmissions
+	sentAt  time.Time // last sent time
+}
+
+// SendReliable sends msg with a new delivery ID, and keeps it until the client acks.
+// The message is retransmitted every retry interval, or when the session's resumed.
+// Returns the delivery ID, and false if msg can't be sent since session's closed,
+// or there's no AckMessageID to ack it.
+func (s *session) SendReliable(msg *Message) (deliveryID uint64, ok bool) {
+	if s.ackMessageID == nil {
+		return 0, false
+	}
+	deliveryID = atomic.AddUint64(&s.deliverySeq, 1)
+	msg.Set(MessageDeliveryKey, deliveryID)
+
+	s.deliveriesMu.Lock()
+	if s.deliveries == nil {
+		s.deliveries = make(map[uint64]*delivery)
+	}
+	s.deliveries[deliveryID] = &delivery{msg: msg, sentAt: time.Now()}
+	s.deliveriesMu.Unlock()
+
+	if s.retryInterval > 0 {
+		s.retryOnce.Do(func() { go s.retryLoop() })
+	}
+	if !s.AllocateContext().SetResponseMessage(msg).Send() {
+		s.deliveriesMu.Lock()
+		delete(s.deliveries, deliveryID)
+		s.deliveriesMu.Unlock()
+		return deliveryID, false
+	}
+	return deliveryID, true
+}
+
+// retryLoop retransmits the messages not acked in retry interval, until the session's closed.
+func (s *session) retryLoop() {
+	ticker := time.NewTicker(s.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closedC:
+			return
+		case now := <-ticker.C:
+			_, _, lostC := s.connection()
+			select {
+			case <-lostC:
+				continue // waiting to be resumed
+			default:
+			}
+			s.retransmit(now.Add(-s.retryInterval))
+		}
+	}
+}
+
+// retransmit sends the messages not acked which are last sent before the time again.
+// The ones reaching max retries are dropped and reported with ErrDeliveryFailed.
+func (s *session) retransmit(before time.Time) {
+	var resend, failed []*Message
+	now := time.Now()
+	s.deliveriesMu.Lock()
+	for id, d := range s.deliveries {
+		if d.sentAt.After(before) {
+			continue
+		}
+		if s.maxRetries > 0 && d.retries >= s.maxRetries {
+			delete(s.deliveries, id)
+			failed = append(failed, d.msg)
+			continue
+		}
+		d.retries++
+		d.sentAt = now
+		resend = append(resend, d.msg)
+	}
+	s.deliveriesMu.Unlock()
+
+	for _, msg := range failed {
+		s.deliveryFailed(msg, ErrDeliveryFailed)
+	}
+	for _, msg := range resend {
+		s.AllocateContext().SetResponseMessage(msg).Send()
+	}
+}
+
+// deliverAck removes the message acked by msg.
+// Returns false if msg is not an ack.
+func (s *session) deliverAck(msg *Message) bool {
+	if s.ackMessageID == nil || normalizeID(msg.ID()) != s.ackMessageID {
+		return false
+	}
+	v, has := msg.Get(MessageDeliveryKey)
+	if !has {
+		return false
+	}
+	if deliveryID, err := cast.ToUint64E(v); err == nil {
+		s.deliveriesMu.Lock()
+		delete(s.deliveries, deliveryID)
+		s.deliveriesMu.Unlock()
+	}
+	return true
+}
+
+// failDeliveries drops all the messages not acked, and reports them with err.
+func (s *session) failDeliveries(err error) {
+	s.deliveriesMu.Lock()
+	deliveries := s.deliveries
+	s.deliveries = nil
+	s.deliveriesMu.Unlock()
+	for _, d := range deliveries {
+		s.deliveryFailed(d.msg, err)
+	}
+}
+
+func (s *session) deliveryFailed(msg *Message, err error) {
+	_log.Tracef("session %s delivery %v failed: %s", s.id, msg.MustGet(MessageDeliveryKey), err)
+	if s.onDeliveryFailed != nil {
+		s.onDeliveryFailed(s, msg, err)
+	}
+}
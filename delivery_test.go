@@ -0,0 +1,171 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_session_SendReliable(t *testing.T) {
+	t.Run("when client acks", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{respQueueSize: 10, ackMessageID: 99})
+		id, ok := sess.SendReliable(NewMessage(1, []byte("item")))
+		assert.True(t, ok)
+		assert.EqualValues(t, 1, id)
+		ctx := <-sess.respStream
+		assert.Equal(t, id, ctx.Response().MustGet(MessageDeliveryKey))
+		assert.Len(t, sess.deliveries, 1)
+
+		ackMsg := NewMessage(99, nil)
+		ackMsg.Set(MessageDeliveryKey, int(id)) // packer may unpack it in another type
+		r := newRouter()
+		r.register(99, func(ctx Context) { assert.Fail(t, "ack should not be routed") })
+		sess.handleInbound(r, ackMsg)
+		assert.Empty(t, sess.deliveries)
+	})
+	t.Run("when ack is not enabled", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{respQueueSize: 10})
+		_, ok := sess.SendReliable(NewMessage(1, []byte("item")))
+		assert.False(t, ok)
+		assert.Empty(t, sess.respStream)
+		assert.Empty(t, sess.deliveries)
+	})
+	t.Run("when session is closed", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{ackMessageID: 99})
+		sess.Close()
+		_, ok := sess.SendReliable(NewMessage(1, []byte("item")))
+		assert.False(t, ok)
+		assert.Empty(t, sess.deliveries)
+	})
+	t.Run("when retries are exhausted", func(t *testing.T) {
+		failed := make(chan error, 1)
+		sess := newSession(nil, &sessionOption{
+			respQueueSize: 10,
+			ackMessageID:  99,
+			retryInterval: time.Millisecond * 10,
+			maxRetries:    2,
+			onDeliveryFailed: func(sess Session, msg *Message, err error) {
+				assert.Equal(t, []byte("item"), msg.Data())
+				failed <- err
+			},
+		})
+		defer sess.Close()
+		_, ok := sess.SendReliable(NewMessage(1, []byte("item")))
+		assert.True(t, ok)
+		assert.ErrorIs(t, <-failed, ErrDeliveryFailed)
+		assert.Len(t, sess.respStream, 3) // sent once and retransmitted twice
+	})
+}
+
+func Test_session_deliverAck(t *testing.T) {
+	sess := newSession(nil, &sessionOption{})
+	assert.False(t, sess.deliverAck(NewMessage(99, nil))) // ack not enabled
+
+	sess = newSession(nil, &sessionOption{ackMessageID: 99})
+	assert.False(t, sess.deliverAck(NewMessage(1, nil)))
+	assert.False(t, sess.deliverAck(NewMessage(99, nil))) // without delivery ID
+
+	ackMsg := NewMessage(99, nil)
+	ackMsg.Set(MessageDeliveryKey, "invalid")
+	assert.True(t, sess.deliverAck(ackMsg))
+
+	// ack ID of another integer type
+	ackMsg = NewMessage(uint16(99), nil)
+	ackMsg.Set(MessageDeliveryKey, "invalid")
+	assert.True(t, sess.deliverAck(ackMsg))
+}
+
+func Test_session_retransmit(t *testing.T) {
+	sess := newSession(nil, &sessionOption{respQueueSize: 10, ackMessageID: 99})
+	_, ok := sess.SendReliable(NewMessage(1, []byte("item")))
+	assert.True(t, ok)
+	<-sess.respStream
+
+	sess.retransmit(time.Now().Add(-time.Minute)) // not due yet
+	assert.Empty(t, sess.respStream)
+
+	sess.retransmit(time.Now())
+	assert.Len(t, sess.respStream, 1)
+	assert.Equal(t, 1, sess.deliveries[1].retries)
+}
+
+func TestServer_OnDeliveryFailed(t *testing.T) {
+	server := NewServer(&ServerOption{DoNotPrintRoutes: true, RespQueueSize: -1, AckMessageID: 99})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	server.OnSessionCreate = func(sess Session) {
+		_, ok := sess.SendReliable(NewMessage(1, []byte("item")))
+		assert.True(t, ok)
+	}
+	server.OnDeliveryFailed = func(sess Session, msg *Message, err error) {
+		defer wg.Done()
+		assert.ErrorIs(t, err, ErrSessionClosed)
+		assert.Equal(t, []byte("item"), msg.Data())
+	}
+	go func() {
+		assert.ErrorIs(t, server.Run("localhost:0"), ErrServerStopped)
+	}()
+	<-server.acceptingC
+	defer func() { assert.NoError(t, server.Stop()) }()
+
+	cli, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	msg, err := server.Packer.Unpack(cli)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("item"), msg.Data())
+	assert.NoError(t, cli.Close()) // close without ack
+	wg.Wait()
+}
+
+func TestServer_SendReliable_acked(t *testing.T) {
+	packer, err := NewLengthFieldPacker(LengthFieldPackerOption{
+		LengthFieldSize: 4,
+		IDFieldOffset:   4,
+		IDFieldSize:     4,
+		HeaderFields:    []HeaderField{{Key: MessageDeliveryKey, Offset: 8, Size: 8}},
+	})
+	require.NoError(t, err)
+	server := NewServer(&ServerOption{Packer: packer, DoNotPrintRoutes: true, RespQueueSize: -1, AckMessageID: 99})
+	server.AddRoute(99, func(ctx Context) { assert.Fail(t, "ack should not be routed") })
+	sessC := make(chan Session, 1)
+	server.OnSessionCreate = func(sess Session) {
+		_, ok := sess.SendReliable(NewMessage(1, []byte("item")))
+		assert.True(t, ok)
+		sessC <- sess
+	}
+	server.OnDeliveryFailed = func(sess Session, msg *Message, err error) {
+		assert.Fail(t, "acked message should not fail")
+	}
+	go func() {
+		assert.ErrorIs(t, server.Run("localhost:0"), ErrServerStopped)
+	}()
+	<-server.acceptingC
+	defer func() { assert.NoError(t, server.Stop()) }()
+
+	cli, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	msg, err := packer.Unpack(cli)
+	require.NoError(t, err)
+	deliveryID, has := msg.Get(MessageDeliveryKey) // carried on the wire
+	require.True(t, has)
+
+	ackMsg := NewMessage(99, nil)
+	ackMsg.Set(MessageDeliveryKey, deliveryID)
+	ackBytes, err := packer.Pack(ackMsg)
+	require.NoError(t, err)
+	_, err = cli.Write(ackBytes)
+	require.NoError(t, err)
+
+	sess := <-sessC
+	assert.Eventually(t, func() bool {
+		s := sess.(*session)
+		s.deliveriesMu.Lock()
+		defer s.deliveriesMu.Unlock()
+		return len(s.deliveries) == 0
+	}, time.Second, time.Millisecond*5)
+	assert.NoError(t, cli.Close())
+	<-sess.AfterCloseHook()
+}
@@ -0,0 +1,34 @@
+package easytcp
+
+// FilterFunc is the function type for inbound filters.
+// A filter sees each message right after it's unpacked, before a Context is allocated for routing.
+// It returns the message to go on with, which can be msg itself or a rewritten one,
+// or nil to drop the message. To reply directly, send a Context to sess and return nil.
+type FilterFunc func(sess Session, msg *Message) *Message
+
+// registerFilter stores the inbound filters.
+func (r *Router) registerFilter(f ...FilterFunc) {
+	fs := make([]FilterFunc, 0, len(f))
+	for _, ff := range f {
+		if ff != nil {
+			fs = append(fs, ff)
+		}
+	}
+	if len(fs) == 0 {
+		return
+	}
+	r.update(func(t *routeTable) {
+		t.filters = append(t.filters, fs...)
+	})
+}
+
+// filterInbound passes msg through the filters in the order of registration.
+// Returns nil if msg is dropped by any filter.
+func (r *Router) filterInbound(sess Session, msg *Message) *Message {
+	for _, f := range r.routes().filters {
+		if msg = f(sess, msg); msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
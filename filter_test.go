@@ -0,0 +1,65 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRouter_filterInbound(t *testing.T) {
+	rt := newRouter()
+	msg := NewMessage(1, []byte("test"))
+	assert.Equal(t, msg, rt.filterInbound(nil, msg)) // no filters
+
+	var result []string
+	rt.registerFilter(nil)
+	rt.registerFilter(func(sess Session, msg *Message) *Message {
+		result = append(result, "f1")
+		if msg.ID() == 2 {
+			return nil
+		}
+		return msg
+	}, nil, func(sess Session, msg *Message) *Message {
+		result = append(result, "f2")
+		return NewMessage(msg.ID(), []byte("rewritten"))
+	})
+	assert.Len(t, rt.routes().filters, 2)
+
+	got := rt.filterInbound(nil, msg)
+	assert.Equal(t, []byte("rewritten"), got.Data())
+	assert.Equal(t, []string{"f1", "f2"}, result)
+
+	result = nil
+	assert.Nil(t, rt.filterInbound(nil, NewMessage(2, nil)))
+	assert.Equal(t, []string{"f1"}, result)
+}
+
+func TestTCPSession_handleInbound_filter(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	var handled []interface{}
+	s.AddRoute(1, func(ctx Context) { handled = append(handled, ctx.Request().ID()) })
+	s.AddRoute(2, func(ctx Context) { handled = append(handled, ctx.Request().ID()) })
+
+	// only login (ID 1) is allowed before auth
+	authed := false
+	s.UseFilter(func(sess Session, msg *Message) *Message {
+		if authed || msg.ID() == 1 {
+			return msg
+		}
+		sess.AllocateContext().SetResponseMessage(NewMessage(401, nil)).Send()
+		return nil
+	})
+
+	sess := newSession(nil, &sessionOption{respQueueSize: 10})
+	sess.handleInbound(s.router, NewMessage(2, nil))
+	assert.Empty(t, handled)
+	ctx := <-sess.respStream
+	assert.EqualValues(t, 401, ctx.Response().ID())
+	assert.EqualValues(t, 1, sess.Stats().FramesIn) // counted even dropped
+
+	sess.handleInbound(s.router, NewMessage(1, nil))
+	assert.Equal(t, []interface{}{1}, handled)
+
+	authed = true
+	sess.handleInbound(s.router, NewMessage(2, nil))
+	assert.Equal(t, []interface{}{1, 2}, handled)
+}
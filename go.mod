@@ -1,6 +1,6 @@
 module github.com/DarthPestilane/easytcp
 
-go 1.17
+go 1.18
 
 require (
 	github.com/golang/mock v1.5.0
@@ -0,0 +1,18 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_goroutineID(t *testing.T) {
+	id := goroutineID()
+	assert.NotZero(t, id)
+	assert.Equal(t, id, goroutineID())
+
+	otherC := make(chan uint64)
+	go func() { otherC <- goroutineID() }()
+	other := <-otherC
+	assert.NotZero(t, other)
+	assert.NotEqual(t, id, other)
+}
@@ -62,11 +62,10 @@ func main() {
 			respMsg := easytcp.NewMessage(common.MsgIdBroadCastAck, []byte(respData))
 			go func() {
 				targetSession.AllocateContext().SetResponseMessage(respMsg).Send()
-				// can also write like this.
-				// ctx.Copy().SetResponseMessage(respMsg).SendTo(targetSession)
-				// or this.
-				// ctx.Copy().SetSession(targetSession).SetResponseMessage(respMsg).Send()
 			}()
+			// can also write like this, ctx must be detached before the handler returns.
+			// detached := ctx.Detach()
+			// go detached.SetResponseMessage(respMsg).SendTo(targetSession)
 		}
 
 		ctx.SetResponseMessage(easytcp.NewMessage(common.MsgIdBroadCastAck, []byte("broadcast done")))
@@ -0,0 +1,50 @@
+package easytcp
+
+import (
+	"math"
+	"reflect"
+)
+
+// normalizeID converts id of any integer kind, including the named ones, to a canonical key,
+// so that the IDs of different integer types with the same value are equal.
+// The key is an int if the value fits in int, or an int64 or uint64 otherwise.
+// IDs of other kinds are returned as is.
+func normalizeID(id interface{}) interface{} {
+	if _, ok := id.(int); ok || id == nil {
+		return id
+	}
+	v := reflect.ValueOf(id)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		if n >= math.MinInt && n <= math.MaxInt {
+			return int(n)
+		}
+		return n
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u <= math.MaxInt {
+			return int(u)
+		}
+		if u <= math.MaxInt64 {
+			return int64(u)
+		}
+		return u
+	}
+	return id
+}
+
+// integerID converts id of any integer kind to int64.
+// Returns false if id is not an integer or overflows int64.
+func integerID(id interface{}) (int64, bool) {
+	v := reflect.ValueOf(id)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u <= math.MaxInt64 {
+			return int64(u), true
+		}
+	}
+	return 0, false
+}
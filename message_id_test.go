@@ -0,0 +1,75 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+type testMsgID uint16
+
+func Test_normalizeID(t *testing.T) {
+	cases := []struct {
+		id     interface{}
+		expect interface{}
+	}{
+		{nil, nil},
+		{1, 1},
+		{int8(1), 1},
+		{int64(-1), -1},
+		{uint32(1), 1},
+		{testMsgID(1), 1},
+		{uint64(math.MaxUint64), uint64(math.MaxUint64)},
+		{"1", "1"},
+		{1.0, 1.0},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.expect, normalizeID(c.id), "id %v(%T)", c.id, c.id)
+	}
+}
+
+func Test_integerID(t *testing.T) {
+	n, ok := integerID(testMsgID(1))
+	assert.True(t, ok)
+	assert.EqualValues(t, 1, n)
+	_, ok = integerID(uint64(math.MaxUint64))
+	assert.False(t, ok)
+	_, ok = integerID("1")
+	assert.False(t, ok)
+}
+
+func TestRouter_normalizedID(t *testing.T) {
+	rt := newRouter()
+	var handled []interface{}
+	handler := func(ctx Context) { handled = append(handled, ctx.Request().ID()) }
+	rt.register(uint32(1), handler)
+	rt.register(testMsgID(2), handler)
+	rt.register(int64(math.MaxInt64), handler)
+
+	for _, id := range []interface{}{1, uint32(1), testMsgID(2), 2, int64(math.MaxInt64), uint64(math.MaxInt64)} {
+		rt.handleRequest(&routeContext{reqMsg: NewMessage(id, nil)})
+	}
+	assert.Len(t, handled, 6)
+
+	// same type overrides
+	rt.register(uint32(1), handler)
+
+	// conflicts
+	assert.Panics(t, func() { rt.register(1, handler) })
+	assert.Panics(t, func() { rt.replace(testMsgID(1), handler) })
+	assert.Equal(t, uint32(1), rt.routes().rawIDs[1])
+
+	rt.replace(uint32(1), nil)
+	rt.remove(testMsgID(2))
+	assert.Len(t, rt.routes().handlerMapper, 1)
+	rt.register(1, handler) // no conflict after removed
+}
+
+func Test_normalizeRateLimits(t *testing.T) {
+	assert.Nil(t, normalizeRateLimits(nil))
+	limit := &RateLimit{}
+	assert.Equal(t, map[interface{}]*RateLimit{1: limit, "a": limit}, normalizeRateLimits(map[interface{}]*RateLimit{uint8(1): limit, "a": limit}))
+	assert.Panics(t, func() {
+		normalizeRateLimits(map[interface{}]*RateLimit{uint8(1): limit, testMsgID(1): limit})
+	})
+}
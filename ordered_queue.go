@@ -0,0 +1,84 @@
+package easytcp
+
+import (
+	"sync"
+)
+
+// orderedQueue runs the tasks with the same key in order,
+// and the tasks with different keys concurrently.
+type orderedQueue struct {
+	mu      sync.Mutex
+	lanes   map[interface{}][]func() // pending tasks of each running key
+	slots   chan struct{}            // holds a token for each task pending or running, limits the tasks of all lanes
+	cancelC <-chan struct{}          // stops waiting for a slot or the pool
+
+	// spawn runs the drain of a lane in another goroutine.
+	// Returns false if the drain won't run.
+	spawn func(drain func()) bool
+}
+
+// newOrderedQueue creates an orderedQueue, which holds at most size tasks.
+// Each key's tasks are drained in a new goroutine, or in a worker of pool if pool is not nil.
+// cancelC stops waiting for a slot or the pool.
+func newOrderedQueue(pool *workerPool, size int, cancelC <-chan struct{}) *orderedQueue {
+	q := &orderedQueue{
+		lanes:   make(map[interface{}][]func()),
+		slots:   make(chan struct{}, size),
+		cancelC: cancelC,
+	}
+	if pool != nil {
+		q.spawn = func(drain func()) bool { return pool.submit(drain, cancelC) }
+	} else {
+		q.spawn = func(drain func()) bool {
+			go drain()
+			return true
+		}
+	}
+	return q
+}
+
+// push appends task to the lane of key.
+// A lane's drained in a new goroutine if it's not running.
+// Blocks when the queue is full, and drops task if cancelC is closed meanwhile.
+func (q *orderedQueue) push(key interface{}, task func()) {
+	select {
+	case q.slots <- struct{}{}:
+	case <-q.cancelC:
+		return
+	}
+	q.mu.Lock()
+	lane, running := q.lanes[key]
+	q.lanes[key] = append(lane, task)
+	q.mu.Unlock()
+	if running {
+		return
+	}
+	if !q.spawn(func() { q.drain(key) }) {
+		q.mu.Lock()
+		dropped := len(q.lanes[key])
+		delete(q.lanes, key)
+		q.mu.Unlock()
+		for i := 0; i < dropped; i++ {
+			<-q.slots
+		}
+	}
+}
+
+// drain runs tasks of key's lane one by one, until the lane is empty.
+func (q *orderedQueue) drain(key interface{}) {
+	for {
+		q.mu.Lock()
+		lane := q.lanes[key]
+		if len(lane) == 0 {
+			delete(q.lanes, key)
+			q.mu.Unlock()
+			return
+		}
+		task := lane[0]
+		lane[0] = nil
+		q.lanes[key] = lane[1:]
+		q.mu.Unlock()
+		task()
+		<-q.slots
+	}
+}
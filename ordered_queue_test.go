@@ -0,0 +1,128 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_orderedQueue_push(t *testing.T) {
+	t.Run("it runs tasks of the same key in order", func(t *testing.T) {
+		q := newOrderedQueue(nil, 10, nil)
+		var result []int
+		wg := sync.WaitGroup{}
+		for i := 0; i < 100; i++ {
+			i := i
+			wg.Add(1)
+			q.push("key", func() {
+				defer wg.Done()
+				result = append(result, i)
+			})
+		}
+		wg.Wait()
+		for i, v := range result {
+			assert.Equal(t, i, v)
+		}
+	})
+	t.Run("it runs tasks of different keys concurrently", func(t *testing.T) {
+		q := newOrderedQueue(nil, 10, nil)
+		release := make(chan struct{})
+		done := make(chan struct{})
+		q.push(1, func() { <-release })
+		q.push(2, func() { close(release) })
+		q.push(1, func() { close(done) })
+		<-done
+
+		time.Sleep(time.Millisecond * 5)
+		q.mu.Lock()
+		assert.Empty(t, q.lanes)
+		q.mu.Unlock()
+	})
+	t.Run("when drains in worker pool", func(t *testing.T) {
+		p := newWorkerPool(2, 0)
+		p.start()
+		defer p.stop()
+
+		q := newOrderedQueue(p, 10, nil)
+		var result []int
+		wg := sync.WaitGroup{}
+		for i := 0; i < 100; i++ {
+			i := i
+			wg.Add(1)
+			q.push(nil, func() {
+				defer wg.Done()
+				result = append(result, i)
+			})
+		}
+		wg.Wait()
+		for i, v := range result {
+			assert.Equal(t, i, v)
+		}
+	})
+	t.Run("when worker pool is stopped", func(t *testing.T) {
+		p := newWorkerPool(1, 0)
+		p.stop()
+		q := newOrderedQueue(p, 10, nil)
+		q.push(1, func() { assert.Fail(t, "should not run") })
+		assert.Empty(t, q.lanes)
+		assert.Empty(t, q.slots)
+	})
+	t.Run("when queue is full", func(t *testing.T) {
+		cancelC := make(chan struct{})
+		q := newOrderedQueue(nil, 2, cancelC)
+		release := make(chan struct{})
+		q.push(1, func() { <-release })
+		q.push(2, func() { <-release })
+
+		pushed := make(chan struct{})
+		go func() {
+			q.push(1, func() {})
+			close(pushed)
+		}()
+		select {
+		case <-pushed:
+			assert.Fail(t, "push should be blocked")
+		case <-time.After(time.Millisecond * 20):
+		}
+		close(release)
+		<-pushed
+
+		// tasks are dropped when canceled
+		hold := make(chan struct{})
+		defer close(hold)
+		q.push(3, func() { <-hold })
+		q.push(3, func() { <-hold })
+		close(cancelC)
+		q.push(3, func() { assert.Fail(t, "should not run") })
+	})
+}
+
+func TestTCPSession_dispatch_ordered(t *testing.T) {
+	r := newRouter()
+	r.register(1, func(ctx Context) {
+		time.Sleep(time.Millisecond * 5 * time.Duration(6-len(ctx.Request().Data())))
+		ctx.SetResponseMessage(NewMessage(2, ctx.Request().Data()))
+	})
+
+	sess := newSession(nil, &sessionOption{orderedRouter: true, respQueueSize: 10})
+	for i := 1; i <= 5; i++ {
+		sess.dispatch(r, NewMessage(1, make([]byte, i)))
+	}
+	for i := 1; i <= 5; i++ {
+		ctx := <-sess.respStream
+		assert.Len(t, ctx.Response().Data(), i)
+	}
+
+	// messages with different keys
+	sess = newSession(nil, &sessionOption{
+		orderedRouter: true,
+		respQueueSize: 10,
+		orderKey:      func(msg *Message) interface{} { return len(msg.Data()) },
+	})
+	for i := 1; i <= 5; i++ {
+		sess.dispatch(r, NewMessage(1, make([]byte, i)))
+	}
+	ctx := <-sess.respStream
+	assert.Len(t, ctx.Response().Data(), 5) // the fastest one
+}
@@ -47,7 +47,10 @@ type DefaultPacker struct {
 }
 
 func (d *DefaultPacker) bytesOrder() binary.ByteOrder {
-	return binary.BigEndian
+	if d.byteOrder == nil {
+		return binary.BigEndian
+	}
+	return d.byteOrder
 }
 
 // SetByteOrder sets the byte order
@@ -0,0 +1,394 @@
+package easytcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/spf13/cast"
+	"io"
+	"math"
+)
+
+// LengthFieldVarint is the LengthFieldSize of an unsigned varint length field.
+const LengthFieldVarint = -1
+
+// IDType is the type of the ID field of LengthFieldPacker.
+type IDType int
+
+const (
+	// IDTypeUint is the unsigned integer ID, unpacked as int, or uint64 if it overflows int.
+	IDTypeUint IDType = iota
+
+	// IDTypeInt is the signed integer ID, unpacked as int.
+	IDTypeInt
+
+	// IDTypeString is the fixed size string ID, right padded with zeros.
+	IDTypeString
+)
+
+// HeaderField is an extra unsigned integer field in the header, mapped to the Message storage.
+type HeaderField struct {
+	// Key is the Message storage key of the field, e.g. MessageSeqKey.
+	Key string
+
+	// Offset is the offset of the field in the header.
+	Offset int
+
+	// Size is the size of the field, can be 1, 2, 4 or 8.
+	Size int
+}
+
+// LengthFieldPackerOption is the option of LengthFieldPacker.
+// A packet is a header followed by data, and the header contains a length field and an ID field.
+// The header bytes out of the fields are written as zeros, and ignored when unpacking.
+type LengthFieldPackerOption struct {
+	// LengthFieldOffset is the offset of the length field in the header.
+	LengthFieldOffset int
+
+	// LengthFieldSize is the size of the length field, can be 1, 2, 4, 8 or LengthFieldVarint.
+	// A varint length field must be the last field of the header.
+	LengthFieldSize int
+
+	// IDFieldOffset is the offset of the ID field in the header.
+	IDFieldOffset int
+
+	// IDFieldSize is the size of the ID field, can be 1, 2, 4 or 8, or any positive size for IDTypeString.
+	// 0 means there's no ID field, and the messages are unpacked with ID 0.
+	IDFieldSize int
+
+	// IDType is the type of the ID field, default to IDTypeUint.
+	IDType IDType
+
+	// ByteOrder is the byte order of the length and ID fields, default to binary.BigEndian.
+	ByteOrder binary.ByteOrder
+
+	// LengthIncludesHeader represents whether the length is the size of the whole packet, or data only.
+	// It can't be used with a varint length field.
+	LengthIncludesHeader bool
+
+	// LengthAdjustment is added to the length to get the size of data, or packet if LengthIncludesHeader.
+	// E.g. -2 if the length covers a 2-byte ID following it as well as data.
+	LengthAdjustment int
+
+	// MaxDataSize is the max size of data, default to 1MB, < 0 means no limit.
+	MaxDataSize int
+
+	// HeaderFields are the extra fields in the header, e.g. the sequence ID of Session.Call.
+	// Pack writes the value stored with the key, or 0 if there's none, and Unpack stores the nonzero values back.
+	HeaderFields []HeaderField
+}
+
+var _ Packer = &LengthFieldPacker{}
+
+// LengthFieldPacker is a Packer with configurable length and ID fields,
+// which can be used to speak the existing protocols without a custom Packer.
+// E.g. the format of DefaultPacker is:
+//
+//	LengthFieldPackerOption{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: 4}
+type LengthFieldPacker struct {
+	opt        LengthFieldPackerOption
+	headerSize int // size of the header, without the varint length field
+}
+
+// NewLengthFieldPacker creates a *LengthFieldPacker with opt.
+// Returns error if opt is invalid.
+func NewLengthFieldPacker(opt LengthFieldPackerOption) (*LengthFieldPacker, error) {
+	if opt.ByteOrder == nil {
+		opt.ByteOrder = binary.BigEndian
+	}
+	if opt.MaxDataSize == 0 {
+		opt.MaxDataSize = 1 << 10 << 10 // 1MB
+	}
+	if opt.LengthFieldOffset < 0 || opt.IDFieldOffset < 0 {
+		return nil, fmt.Errorf("field offset must not be negative")
+	}
+	switch opt.LengthFieldSize {
+	case 1, 2, 4, 8, LengthFieldVarint:
+	default:
+		return nil, fmt.Errorf("invalid length field size: %d", opt.LengthFieldSize)
+	}
+	switch opt.IDType {
+	case IDTypeUint, IDTypeInt:
+		switch opt.IDFieldSize {
+		case 0, 1, 2, 4, 8:
+		default:
+			return nil, fmt.Errorf("invalid ID field size: %d", opt.IDFieldSize)
+		}
+	case IDTypeString:
+		if opt.IDFieldSize < 0 {
+			return nil, fmt.Errorf("invalid ID field size: %d", opt.IDFieldSize)
+		}
+	default:
+		return nil, fmt.Errorf("invalid ID type: %d", opt.IDType)
+	}
+
+	idEnd := opt.IDFieldOffset + opt.IDFieldSize
+	fieldsEnd, err := checkHeaderFields(opt)
+	if err != nil {
+		return nil, err
+	}
+	if opt.LengthFieldSize == LengthFieldVarint {
+		if opt.LengthIncludesHeader {
+			return nil, fmt.Errorf("varint length field can't include header")
+		}
+		if (opt.IDFieldSize > 0 && opt.LengthFieldOffset < idEnd) || opt.LengthFieldOffset < fieldsEnd {
+			return nil, fmt.Errorf("varint length field must be the last field of header")
+		}
+		return &LengthFieldPacker{opt: opt, headerSize: opt.LengthFieldOffset}, nil
+	}
+
+	lengthEnd := opt.LengthFieldOffset + opt.LengthFieldSize
+	if opt.IDFieldSize > 0 && opt.IDFieldOffset < lengthEnd && opt.LengthFieldOffset < idEnd {
+		return nil, fmt.Errorf("length field and ID field overlap")
+	}
+	for _, f := range opt.HeaderFields {
+		if f.Offset < lengthEnd && opt.LengthFieldOffset < f.Offset+f.Size {
+			return nil, fmt.Errorf("header field %s and length field overlap", f.Key)
+		}
+	}
+	headerSize := lengthEnd
+	if idEnd > headerSize {
+		headerSize = idEnd
+	}
+	if fieldsEnd > headerSize {
+		headerSize = fieldsEnd
+	}
+	return &LengthFieldPacker{opt: opt, headerSize: headerSize}, nil
+}
+
+// checkHeaderFields validates the header fields of opt, which must not overlap the ID field or each other.
+// Returns the end offset of the header fields.
+func checkHeaderFields(opt LengthFieldPackerOption) (end int, err error) {
+	for i, f := range opt.HeaderFields {
+		switch f.Size {
+		case 1, 2, 4, 8:
+		default:
+			return 0, fmt.Errorf("invalid size of header field %s: %d", f.Key, f.Size)
+		}
+		if f.Offset < 0 {
+			return 0, fmt.Errorf("field offset must not be negative")
+		}
+		fEnd := f.Offset + f.Size
+		if opt.IDFieldSize > 0 && f.Offset < opt.IDFieldOffset+opt.IDFieldSize && opt.IDFieldOffset < fEnd {
+			return 0, fmt.Errorf("header field %s and ID field overlap", f.Key)
+		}
+		for _, other := range opt.HeaderFields[:i] {
+			if f.Offset < other.Offset+other.Size && other.Offset < fEnd {
+				return 0, fmt.Errorf("header field %s and %s overlap", f.Key, other.Key)
+			}
+		}
+		if fEnd > end {
+			end = fEnd
+		}
+	}
+	return end, nil
+}
+
+// Pack implements the Packer Pack method.
+func (p *LengthFieldPacker) Pack(msg *Message) ([]byte, error) {
+	dataSize := len(msg.Data())
+	if p.opt.MaxDataSize > 0 && dataSize > p.opt.MaxDataSize {
+		return nil, fmt.Errorf("the dataSize %d is beyond the max: %d", dataSize, p.opt.MaxDataSize)
+	}
+	length := dataSize - p.opt.LengthAdjustment
+	if p.opt.LengthIncludesHeader {
+		length += p.headerSize
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("the length %d is negative", length)
+	}
+
+	header := make([]byte, p.headerSize, p.headerSize+binary.MaxVarintLen64)
+	if err := p.putID(header, msg.ID()); err != nil {
+		return nil, err
+	}
+	if err := p.putHeaderFields(header, msg); err != nil {
+		return nil, err
+	}
+	if p.opt.LengthFieldSize == LengthFieldVarint {
+		header = header[:p.headerSize+binary.PutUvarint(header[p.headerSize:cap(header)], uint64(length))]
+	} else if err := p.putUint(header[p.opt.LengthFieldOffset:], p.opt.LengthFieldSize, uint64(length)); err != nil {
+		return nil, fmt.Errorf("invalid length: %s", err)
+	}
+
+	buffer := make([]byte, len(header)+dataSize)
+	copy(buffer, header)                   // write header
+	copy(buffer[len(header):], msg.Data()) // write data
+	return buffer, nil
+}
+
+// Unpack implements the Packer Unpack method.
+// Unpack returns the message whose ID is type of int for the integer IDs, and string for IDTypeString.
+func (p *LengthFieldPacker) Unpack(reader io.Reader) (*Message, error) {
+	header := make([]byte, p.headerSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("read header err: %s", err)
+	}
+
+	var length uint64
+	if p.opt.LengthFieldSize == LengthFieldVarint {
+		var err error
+		if length, err = binary.ReadUvarint(&byteReader{reader: reader}); err != nil {
+			if err == io.EOF && p.headerSize == 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("read length err: %s", err)
+		}
+	} else {
+		length = p.uint(header[p.opt.LengthFieldOffset:], p.opt.LengthFieldSize)
+	}
+	if length > math.MaxInt32 {
+		return nil, fmt.Errorf("the length %d is too large", length)
+	}
+	dataSize := int(length) + p.opt.LengthAdjustment
+	if p.opt.LengthIncludesHeader {
+		dataSize -= p.headerSize
+	}
+	if dataSize < 0 {
+		return nil, fmt.Errorf("the dataSize %d is negative", dataSize)
+	}
+	if p.opt.MaxDataSize > 0 && dataSize > p.opt.MaxDataSize {
+		return nil, fmt.Errorf("the dataSize %d is beyond the max: %d", dataSize, p.opt.MaxDataSize)
+	}
+
+	data := make([]byte, dataSize)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, fmt.Errorf("read data err: %s", err)
+	}
+	msg := NewMessage(p.id(header), data)
+	for _, f := range p.opt.HeaderFields {
+		if n := p.uint(header[f.Offset:], f.Size); n != 0 {
+			msg.Set(f.Key, n)
+		}
+	}
+	return msg, nil
+}
+
+// putHeaderFields writes the values stored in msg into the header fields of header.
+func (p *LengthFieldPacker) putHeaderFields(header []byte, msg *Message) error {
+	for _, f := range p.opt.HeaderFields {
+		v, has := msg.Get(f.Key)
+		if !has {
+			continue
+		}
+		n, err := cast.ToUint64E(v)
+		if err != nil {
+			return fmt.Errorf("invalid type of header field %s: %s", f.Key, err)
+		}
+		if err := p.putUint(header[f.Offset:], f.Size, n); err != nil {
+			return fmt.Errorf("invalid header field %s: %s", f.Key, err)
+		}
+	}
+	return nil
+}
+
+// putID writes id into the ID field of header.
+func (p *LengthFieldPacker) putID(header []byte, id interface{}) error {
+	size := p.opt.IDFieldSize
+	if size == 0 {
+		return nil
+	}
+	field := header[p.opt.IDFieldOffset:]
+	switch p.opt.IDType {
+	case IDTypeString:
+		s, err := cast.ToStringE(id)
+		if err != nil {
+			return fmt.Errorf("invalid type of msg.ID: %s", err)
+		}
+		if len(s) > size {
+			return fmt.Errorf("invalid msg.ID: %q is longer than %d", s, size)
+		}
+		copy(field[:size], s)
+	case IDTypeInt:
+		n, err := cast.ToInt64E(id)
+		if err != nil {
+			return fmt.Errorf("invalid type of msg.ID: %s", err)
+		}
+		bits := uint(size * 8)
+		if bits < 64 && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+			return fmt.Errorf("invalid msg.ID: %d overflows %d bytes", n, size)
+		}
+		_ = p.putUint(field, size, uint64(n)&(math.MaxUint64>>(64-bits)))
+	default:
+		n, err := cast.ToUint64E(id)
+		if err != nil {
+			return fmt.Errorf("invalid type of msg.ID: %s", err)
+		}
+		if err := p.putUint(field, size, n); err != nil {
+			return fmt.Errorf("invalid msg.ID: %s", err)
+		}
+	}
+	return nil
+}
+
+// id reads the ID from the ID field of header.
+func (p *LengthFieldPacker) id(header []byte) interface{} {
+	size := p.opt.IDFieldSize
+	if size == 0 {
+		return 0
+	}
+	field := header[p.opt.IDFieldOffset:]
+	switch p.opt.IDType {
+	case IDTypeString:
+		return string(bytes.TrimRight(field[:size], "\x00"))
+	case IDTypeInt:
+		n := p.uint(field, size)
+		bits := uint(size * 8)
+		return normalizeID(int64(n<<(64-bits)) >> (64 - bits)) // sign extend
+	default:
+		return normalizeID(p.uint(field, size))
+	}
+}
+
+// putUint writes n into b in size bytes.
+// Returns error if n overflows.
+func (p *LengthFieldPacker) putUint(b []byte, size int, n uint64) error {
+	if size < 8 && n >= 1<<uint(size*8) {
+		return fmt.Errorf("%d overflows %d bytes", n, size)
+	}
+	switch size {
+	case 1:
+		b[0] = byte(n)
+	case 2:
+		p.opt.ByteOrder.PutUint16(b, uint16(n))
+	case 4:
+		p.opt.ByteOrder.PutUint32(b, uint32(n))
+	default:
+		p.opt.ByteOrder.PutUint64(b, n)
+	}
+	return nil
+}
+
+// uint reads an unsigned integer of size bytes from b.
+func (p *LengthFieldPacker) uint(b []byte, size int) uint64 {
+	switch size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(p.opt.ByteOrder.Uint16(b))
+	case 4:
+		return uint64(p.opt.ByteOrder.Uint32(b))
+	default:
+		return p.opt.ByteOrder.Uint64(b)
+	}
+}
+
+// byteReader reads byte by byte from reader, for reading varint.
+type byteReader struct {
+	reader io.Reader
+	buf    [1]byte
+}
+
+// ReadByte implements the io.ByteReader ReadByte method.
+func (r *byteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(r.reader, r.buf[:]); err != nil {
+		return 0, err
+	}
+	return r.buf[0], nil
+}
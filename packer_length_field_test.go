@@ -0,0 +1,255 @@
+package easytcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestNewLengthFieldPacker(t *testing.T) {
+	invalids := []LengthFieldPackerOption{
+		{LengthFieldSize: 3},
+		{LengthFieldSize: 0},
+		{LengthFieldSize: 4, LengthFieldOffset: -1},
+		{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: 3},
+		{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: -1, IDType: IDTypeString},
+		{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: 4, IDType: 100},
+		{LengthFieldSize: 4, IDFieldOffset: 2, IDFieldSize: 4},
+		{LengthFieldSize: 4, LengthFieldOffset: 2, IDFieldSize: 4},
+		{LengthFieldSize: LengthFieldVarint, IDFieldSize: 4},
+		{LengthFieldSize: LengthFieldVarint, LengthFieldOffset: 4, IDFieldSize: 4, LengthIncludesHeader: true},
+		{LengthFieldSize: 4, HeaderFields: []HeaderField{{Key: "a", Offset: 4, Size: 3}}},
+		{LengthFieldSize: 4, HeaderFields: []HeaderField{{Key: "a", Offset: -1, Size: 1}}},
+		{LengthFieldSize: 4, HeaderFields: []HeaderField{{Key: "a", Offset: 2, Size: 4}}},
+		{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: 4, HeaderFields: []HeaderField{{Key: "a", Offset: 6, Size: 4}}},
+		{LengthFieldSize: 4, HeaderFields: []HeaderField{{Key: "a", Offset: 4, Size: 4}, {Key: "b", Offset: 6, Size: 2}}},
+		{LengthFieldSize: LengthFieldVarint, LengthFieldOffset: 2, HeaderFields: []HeaderField{{Key: "a", Offset: 0, Size: 4}}},
+	}
+	for _, opt := range invalids {
+		p, err := NewLengthFieldPacker(opt)
+		assert.Error(t, err, "%+v", opt)
+		assert.Nil(t, p)
+	}
+
+	p, err := NewLengthFieldPacker(LengthFieldPackerOption{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, 8, p.headerSize)
+	assert.Equal(t, binary.BigEndian, p.opt.ByteOrder)
+	assert.Equal(t, 1<<10<<10, p.opt.MaxDataSize)
+}
+
+func TestLengthFieldPacker_PackAndUnpack(t *testing.T) {
+	cases := []struct {
+		name   string
+		opt    LengthFieldPackerOption
+		id     interface{}
+		packed []byte
+		wantID interface{}
+	}{
+		{
+			name:   "same as DefaultPacker",
+			opt:    LengthFieldPackerOption{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: 4},
+			id:     uint32(1),
+			packed: []byte{0, 0, 0, 4, 0, 0, 0, 1, 't', 'e', 's', 't'},
+			wantID: 1,
+		},
+		{
+			name:   "id before length in little endian",
+			opt:    LengthFieldPackerOption{LengthFieldOffset: 2, LengthFieldSize: 2, IDFieldSize: 2, ByteOrder: binary.LittleEndian},
+			id:     0x0102,
+			packed: []byte{2, 1, 4, 0, 't', 'e', 's', 't'},
+			wantID: 0x0102,
+		},
+		{
+			name:   "length covers header with adjustment",
+			opt:    LengthFieldPackerOption{LengthFieldOffset: 1, LengthFieldSize: 1, IDFieldOffset: 2, IDFieldSize: 1, LengthIncludesHeader: true, LengthAdjustment: -2},
+			id:     7,
+			packed: []byte{0, 9, 7, 't', 'e', 's', 't'},
+			wantID: 7,
+		},
+		{
+			name:   "length covers id",
+			opt:    LengthFieldPackerOption{LengthFieldSize: 2, IDFieldOffset: 2, IDFieldSize: 1, LengthAdjustment: -1},
+			id:     7,
+			packed: []byte{0, 5, 7, 't', 'e', 's', 't'},
+			wantID: 7,
+		},
+		{
+			name:   "signed id",
+			opt:    LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 2, IDType: IDTypeInt},
+			id:     -2,
+			packed: []byte{4, 0xff, 0xfe, 't', 'e', 's', 't'},
+			wantID: -2,
+		},
+		{
+			name:   "8 bytes signed id",
+			opt:    LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 8, IDType: IDTypeInt},
+			id:     int64(-1),
+			packed: []byte{4, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 't', 'e', 's', 't'},
+			wantID: -1,
+		},
+		{
+			name:   "string id",
+			opt:    LengthFieldPackerOption{LengthFieldSize: 2, IDFieldOffset: 2, IDFieldSize: 4, IDType: IDTypeString},
+			id:     "AT",
+			packed: []byte{0, 4, 'A', 'T', 0, 0, 't', 'e', 's', 't'},
+			wantID: "AT",
+		},
+		{
+			name:   "varint length after id",
+			opt:    LengthFieldPackerOption{LengthFieldOffset: 1, LengthFieldSize: LengthFieldVarint, IDFieldSize: 1},
+			id:     3,
+			packed: []byte{3, 4, 't', 'e', 's', 't'},
+			wantID: 3,
+		},
+		{
+			name:   "varint length without id",
+			opt:    LengthFieldPackerOption{LengthFieldSize: LengthFieldVarint},
+			id:     3,
+			packed: []byte{4, 't', 'e', 's', 't'},
+			wantID: 0,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := NewLengthFieldPacker(c.opt)
+			assert.NoError(t, err)
+			packed, err := p.Pack(NewMessage(c.id, []byte("test")))
+			assert.NoError(t, err)
+			assert.Equal(t, c.packed, packed)
+
+			msg, err := p.Unpack(bytes.NewReader(packed))
+			assert.NoError(t, err)
+			assert.Equal(t, c.wantID, msg.ID())
+			assert.Equal(t, []byte("test"), msg.Data())
+		})
+	}
+
+	t.Run("large varint length", func(t *testing.T) {
+		p, err := NewLengthFieldPacker(LengthFieldPackerOption{LengthFieldSize: LengthFieldVarint})
+		assert.NoError(t, err)
+		data := make([]byte, 300)
+		packed, err := p.Pack(NewMessage(nil, data))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0xac, 0x02}, packed[:2])
+		msg, err := p.Unpack(bytes.NewReader(packed))
+		assert.NoError(t, err)
+		assert.Equal(t, data, msg.Data())
+	})
+}
+
+func TestLengthFieldPacker_HeaderFields(t *testing.T) {
+	p, err := NewLengthFieldPacker(LengthFieldPackerOption{
+		LengthFieldSize: 4,
+		IDFieldOffset:   4,
+		IDFieldSize:     2,
+		HeaderFields: []HeaderField{
+			{Key: MessageSeqKey, Offset: 6, Size: 4},
+			{Key: MessageStreamEndKey, Offset: 10, Size: 1},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 11, p.headerSize)
+
+	msg := NewMessage(1, []byte("test"))
+	msg.Set(MessageSeqKey, uint32(0x01020304))
+	msg.Set(MessageStreamEndKey, true)
+	packed, err := p.Pack(msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 4, 0, 1, 1, 2, 3, 4, 1, 't', 'e', 's', 't'}, packed)
+
+	unpacked, err := p.Unpack(bytes.NewReader(packed))
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0x01020304, unpacked.MustGet(MessageSeqKey))
+	assert.EqualValues(t, 1, unpacked.MustGet(MessageStreamEndKey))
+
+	// the missing fields are written as zeros, and not stored back
+	packed, err = p.Pack(NewMessage(1, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0}, packed)
+	unpacked, err = p.Unpack(bytes.NewReader(packed))
+	assert.NoError(t, err)
+	_, has := unpacked.Get(MessageSeqKey)
+	assert.False(t, has)
+
+	// invalid values
+	msg = NewMessage(1, nil)
+	msg.Set(MessageSeqKey, "not a number")
+	_, err = p.Pack(msg)
+	assert.Error(t, err)
+	msg.Set(MessageSeqKey, uint64(1)<<32)
+	_, err = p.Pack(msg)
+	assert.Error(t, err)
+}
+
+func TestLengthFieldPacker_Pack_err(t *testing.T) {
+	newPacker := func(opt LengthFieldPackerOption) *LengthFieldPacker {
+		p, err := NewLengthFieldPacker(opt)
+		assert.NoError(t, err)
+		return p
+	}
+	cases := []struct {
+		name string
+		p    *LengthFieldPacker
+		msg  *Message
+	}{
+		{"data too large", newPacker(LengthFieldPackerOption{LengthFieldSize: 4, MaxDataSize: 2}), NewMessage(nil, []byte("test"))},
+		{"length overflows", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, MaxDataSize: -1}), NewMessage(nil, make([]byte, 256))},
+		{"negative length", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, LengthAdjustment: 10}), NewMessage(nil, []byte("test"))},
+		{"invalid uint id", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 1}), NewMessage("x", nil)},
+		{"uint id overflows", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 1}), NewMessage(256, nil)},
+		{"invalid int id", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 1, IDType: IDTypeInt}), NewMessage("x", nil)},
+		{"int id overflows", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 1, IDType: IDTypeInt}), NewMessage(128, nil)},
+		{"invalid string id", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 1, IDType: IDTypeString}), NewMessage([]int{1}, nil)},
+		{"string id too long", newPacker(LengthFieldPackerOption{LengthFieldSize: 1, IDFieldOffset: 1, IDFieldSize: 1, IDType: IDTypeString}), NewMessage("AT", nil)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			packed, err := c.p.Pack(c.msg)
+			assert.Error(t, err)
+			assert.Nil(t, packed)
+		})
+	}
+}
+
+func TestLengthFieldPacker_Unpack_err(t *testing.T) {
+	p, err := NewLengthFieldPacker(LengthFieldPackerOption{LengthFieldSize: 4, IDFieldOffset: 4, IDFieldSize: 4, MaxDataSize: 8})
+	assert.NoError(t, err)
+
+	msg, err := p.Unpack(bytes.NewReader(nil))
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, msg)
+
+	msg, err = p.Unpack(bytes.NewReader([]byte{0, 0}))
+	assert.Error(t, err)
+	assert.Nil(t, msg)
+
+	msg, err = p.Unpack(bytes.NewReader([]byte{0, 0, 0, 9, 0, 0, 0, 1}))
+	assert.Error(t, err) // beyond max
+	assert.Nil(t, msg)
+
+	msg, err = p.Unpack(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 1}))
+	assert.Error(t, err) // too large
+	assert.Nil(t, msg)
+
+	msg, err = p.Unpack(bytes.NewReader([]byte{0, 0, 0, 4, 0, 0, 0, 1, 't'}))
+	assert.Error(t, err) // data not complete
+	assert.Nil(t, msg)
+
+	p, err = NewLengthFieldPacker(LengthFieldPackerOption{LengthFieldSize: 1, LengthIncludesHeader: true})
+	assert.NoError(t, err)
+	msg, err = p.Unpack(bytes.NewReader([]byte{0}))
+	assert.Error(t, err) // negative data size
+	assert.Nil(t, msg)
+
+	p, err = NewLengthFieldPacker(LengthFieldPackerOption{LengthFieldSize: LengthFieldVarint})
+	assert.NoError(t, err)
+	msg, err = p.Unpack(bytes.NewReader(nil))
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, msg)
+	msg, err = p.Unpack(bytes.NewReader([]byte{0x80}))
+	assert.Error(t, err) // varint not complete
+	assert.Nil(t, msg)
+}
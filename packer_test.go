@@ -62,3 +62,21 @@ func TestDefaultPacker_PackAndUnpack(t *testing.T) {
 		assert.Nil(t, msg)
 	})
 }
+
+func TestDefaultPacker_SetByteOrder(t *testing.T) {
+	packer := NewDefaultPacker()
+	packer.SetByteOrder(binary.LittleEndian)
+	packedBytes, err := packer.Pack(NewMessage(1, []byte("test")))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4, 0, 0, 0, 1, 0, 0, 0}, packedBytes[:8])
+
+	msg, err := packer.Unpack(bytes.NewReader(packedBytes))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, msg.ID())
+	assert.Equal(t, []byte("test"), msg.Data())
+
+	// zero value
+	packedBytes, err = (&DefaultPacker{}).Pack(NewMessage(1, []byte("test")))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 4, 0, 0, 0, 1}, packedBytes[:8])
+}
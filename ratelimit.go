@@ -0,0 +1,143 @@
+package easytcp
+
+import (
+	"fmt"
+	"time"
+)
+
+// RateLimitAction is the action to take when an inbound message exceeds the rate limit.
+type RateLimitAction int
+
+const (
+	// RateLimitDrop drops the message silently.
+	RateLimitDrop RateLimitAction = iota
+
+	// RateLimitDelay holds the read loop until the message is allowed,
+	// so that the client is pushed back by TCP flow control.
+	RateLimitDelay
+
+	// RateLimitReply drops the message and sends the RateLimit.ReplyMessage back.
+	RateLimitReply
+
+	// RateLimitDisconnect closes the session.
+	RateLimitDisconnect
+)
+
+// RateLimit is a token-bucket limit on the inbound messages of a session.
+type RateLimit struct {
+	FramesPerSecond float64         // max frames per second, 0 means no limit.
+	FrameBurst      int             // max frames in a burst, FramesPerSecond is used if <= 0.
+	BytesPerSecond  float64         // max bytes of message data per second, 0 means no limit.
+	ByteBurst       int             // max bytes in a burst, BytesPerSecond is used if <= 0.
+	Action          RateLimitAction // the action to take when the limit is exceeded.
+
+	// ReplyMessage returns the message to reply when Action is RateLimitReply.
+	// Nothing is replied if it's nil or returns nil.
+	ReplyMessage func(reqMsg *Message) *Message
+}
+
+// tokenBucket is a token bucket which is not goroutine safe.
+type tokenBucket struct {
+	rate   float64   // tokens refilled per second
+	burst  float64   // max tokens
+	tokens float64   // current tokens, can be negative after reserve
+	last   time.Time // last refilled time
+}
+
+// newTokenBucket creates a full tokenBucket.
+// Returns nil if rate is not positive, which means no limit.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+// cost caps n to burst, otherwise a message larger than burst would never be allowed.
+func (b *tokenBucket) cost(n float64) float64 {
+	if n > b.burst {
+		return b.burst
+	}
+	return n
+}
+
+// wait returns the duration to wait until n tokens are available.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	lack := b.cost(n) - b.tokens
+	if lack <= 0 {
+		return 0
+	}
+	return time.Duration(lack / b.rate * float64(time.Second))
+}
+
+// rateLimiter limits both frames and bytes according to a RateLimit.
+type rateLimiter struct {
+	limit  *RateLimit
+	frames *tokenBucket // nil means no limit on frames
+	bytes  *tokenBucket // nil means no limit on bytes
+}
+
+func newRateLimiter(limit *RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:  limit,
+		frames: newTokenBucket(limit.FramesPerSecond, limit.FrameBurst),
+		bytes:  newTokenBucket(limit.BytesPerSecond, limit.ByteBurst),
+	}
+}
+
+// reserve refills the buckets and returns how long to wait until a message of size is allowed.
+// The tokens are taken only when commit is true or there's no need to wait.
+func (l *rateLimiter) reserve(now time.Time, size int, commit bool) time.Duration {
+	var wait time.Duration
+	if l.frames != nil {
+		l.frames.refill(now)
+		wait = l.frames.wait(1)
+	}
+	if l.bytes != nil {
+		l.bytes.refill(now)
+		if w := l.bytes.wait(float64(size)); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 && !commit {
+		return wait
+	}
+	if l.frames != nil {
+		l.frames.tokens -= l.frames.cost(1)
+	}
+	if l.bytes != nil {
+		l.bytes.tokens -= l.bytes.cost(float64(size))
+	}
+	return wait
+}
+
+// normalizeRateLimits returns a copy of limits whose keys are normalized by normalizeID.
+// Panics if two message IDs have the same normalized key.
+func normalizeRateLimits(limits map[interface{}]*RateLimit) map[interface{}]*RateLimit {
+	if limits == nil {
+		return nil
+	}
+	normalized := make(map[interface{}]*RateLimit, len(limits))
+	for id, limit := range limits {
+		key := normalizeID(id)
+		if _, has := normalized[key]; has {
+			panic(fmt.Sprintf("easytcp: duplicated message ID %v(%T) in MessageRateLimits", id, id))
+		}
+		normalized[key] = limit
+	}
+	return normalized
+}
@@ -0,0 +1,154 @@
+package easytcp
+
+import (
+	"fmt"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+func Test_newTokenBucket(t *testing.T) {
+	assert.Nil(t, newTokenBucket(0, 10))
+
+	b := newTokenBucket(10, 0)
+	assert.EqualValues(t, 10, b.burst)
+	assert.EqualValues(t, 10, b.tokens)
+
+	b = newTokenBucket(10, 5)
+	assert.EqualValues(t, 5, b.burst)
+	assert.EqualValues(t, 5, b.tokens)
+}
+
+func Test_rateLimiter_reserve(t *testing.T) {
+	t.Run("when limits frames", func(t *testing.T) {
+		l := newRateLimiter(&RateLimit{FramesPerSecond: 10, FrameBurst: 2})
+		now := time.Now()
+		assert.Zero(t, l.reserve(now, 0, false))
+		assert.Zero(t, l.reserve(now, 0, false))
+		assert.Equal(t, time.Millisecond*100, l.reserve(now, 0, false)) // not taken
+		assert.Equal(t, time.Millisecond*100, l.reserve(now, 0, true))  // taken
+		assert.Equal(t, time.Millisecond*200, l.reserve(now, 0, false))
+
+		// refilled
+		assert.Zero(t, l.reserve(now.Add(time.Millisecond*200), 0, false))
+	})
+	t.Run("when limits bytes", func(t *testing.T) {
+		l := newRateLimiter(&RateLimit{BytesPerSecond: 100})
+		now := time.Now()
+		assert.Zero(t, l.reserve(now, 60, false))
+		assert.Equal(t, time.Millisecond*200, l.reserve(now, 60, false))
+		assert.Zero(t, l.reserve(now.Add(time.Millisecond*200), 60, false))
+
+		// size beyond burst is capped
+		assert.Equal(t, time.Second, l.reserve(now.Add(time.Millisecond*200), 1000, false))
+	})
+	t.Run("when limits frames and bytes", func(t *testing.T) {
+		l := newRateLimiter(&RateLimit{FramesPerSecond: 100, BytesPerSecond: 100})
+		now := time.Now()
+		assert.Zero(t, l.reserve(now, 100, false))
+		assert.Equal(t, time.Second, l.reserve(now, 100, false))
+		assert.EqualValues(t, 99, l.frames.tokens) // frames not taken when bytes exceeded
+	})
+}
+
+func TestTCPSession_allowInbound(t *testing.T) {
+	t.Run("when there's no rate limit", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{})
+		for i := 0; i < 100; i++ {
+			assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		}
+	})
+	t.Run("when action is drop", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{
+			rateLimit: &RateLimit{FramesPerSecond: 1, Action: RateLimitDrop},
+		})
+		assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		assert.False(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+	})
+	t.Run("when action is delay", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{
+			rateLimit: &RateLimit{FramesPerSecond: 50, FrameBurst: 1, Action: RateLimitDelay},
+		})
+		assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		begin := time.Now()
+		assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		assert.True(t, time.Since(begin) >= time.Millisecond*15)
+
+		sess.Close()
+		assert.False(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+
+		// connection lost while delayed
+		sess = newSession(nil, &sessionOption{
+			rateLimit: &RateLimit{FramesPerSecond: 1, FrameBurst: 1, Action: RateLimitDelay},
+			resumable: true,
+		})
+		assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		_, _, lostC := sess.connection()
+		sess.loseConn(lostC)
+		assert.False(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+	})
+	t.Run("when action is reply", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{
+			respQueueSize: 10,
+			rateLimit: &RateLimit{FramesPerSecond: 1, Action: RateLimitReply, ReplyMessage: func(reqMsg *Message) *Message {
+				return NewMessage(reqMsg.ID(), []byte("too fast"))
+			}},
+		})
+		assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		assert.False(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		ctx := <-sess.respStream
+		assert.Equal(t, []byte("too fast"), ctx.Response().Data())
+	})
+	t.Run("when action is disconnect", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{
+			rateLimit: &RateLimit{FramesPerSecond: 1, Action: RateLimitDisconnect},
+		})
+		assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		assert.False(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		_, ok := <-sess.closedC
+		assert.False(t, ok)
+	})
+	t.Run("when message id has its own limit", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{
+			rateLimit: &RateLimit{FramesPerSecond: 1},
+			msgRateLimits: map[interface{}]*RateLimit{
+				2: {FramesPerSecond: 3},
+			},
+		})
+		assert.True(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		assert.False(t, sess.allowInbound(NewMessage(1, []byte("test"))))
+		for i := 0; i < 3; i++ {
+			assert.True(t, sess.allowInbound(NewMessage(2, []byte("test"))))
+		}
+		assert.False(t, sess.allowInbound(NewMessage(2, []byte("test"))))
+	})
+}
+
+func TestTCPSession_readInbound_rateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	count := 0
+	packer := NewMockPacker(ctrl)
+	packer.EXPECT().Unpack(gomock.Any()).Times(4).DoAndReturn(func(_ io.Reader) (*Message, error) {
+		count++
+		if count > 3 {
+			return nil, fmt.Errorf("unpack error")
+		}
+		return NewMessage(1, []byte("test")), nil
+	})
+
+	handled := 0
+	r := newRouter()
+	r.register(1, func(ctx Context) { handled++ })
+
+	sess := newSession(nil, &sessionOption{
+		Packer:        packer,
+		respQueueSize: 10,
+		rateLimit:     &RateLimit{FramesPerSecond: 1, Action: RateLimitDrop},
+	})
+	sess.readInbound(r, 0)
+	assert.Equal(t, 1, handled)
+}
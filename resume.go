@@ -0,0 +1,156 @@
+package easytcp
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// connection returns the current connection, the reader of it and the channel closed when it's lost.
+// Read and write loops should stick to the returned ones, since a resumable session can be attached with a new connection.
+func (s *session) connection() (conn, reader net.Conn, lostC chan struct{}) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.conn, s.reader, s.connLostC
+}
+
+// resumeKickInterval is the interval to kick the connection out when resuming a session.
+const resumeKickInterval = time.Millisecond * 100
+
+// loseConn is called when the connection of lostC is lost.
+// A resumable session waits to be resumed by another connection, while others are closed.
+func (s *session) loseConn(lostC chan struct{}) {
+	if s.resumeToken == "" {
+		s.Close()
+		return
+	}
+	s.connMu.Lock()
+	select {
+	case <-lostC:
+	default:
+		close(lostC)
+	}
+	s.connMu.Unlock()
+}
+
+// detach marks the session as waiting to be resumed, and releases the ownership.
+// Must be called by the owner, after the write loop exited.
+func (s *session) detach() (resumedC <-chan struct{}) {
+	s.resumedC = make(chan struct{})
+	resumedC = s.resumedC
+	<-s.owner
+	return resumedC
+}
+
+// attach binds conn to the detached session.
+// Must be called by the owner.
+func (s *session) attach(conn net.Conn) {
+	s.connMu.Lock()
+	s.conn = conn
+	s.reader = &countingConn{Conn: conn, n: &s.bytesIn}
+	s.connLostC = make(chan struct{})
+	s.connMu.Unlock()
+	close(s.resumedC)
+}
+
+// takeover resumes the session of the token in reqMsg with the connection,
+// if reqMsg is the first message read and a resume message.
+// Returns true if it's resumed, and the session should stop reading.
+func (s *session) takeover(reqMsg *Message) bool {
+	if s.resume == nil || atomic.LoadUint64(&s.framesIn) > 0 || normalizeID(reqMsg.ID()) != s.resumeMessageID {
+		return false
+	}
+	token := string(reqMsg.Data())
+	if token == s.resumeToken {
+		return false
+	}
+	conn, _, _ := s.connection()
+	old := s.resume(token, conn)
+	if old == nil {
+		return false
+	}
+	s.takeoverC <- old
+	return true
+}
+
+// isClosed returns whether the session is closed.
+func (s *session) isClosed() bool {
+	select {
+	case <-s.closedC:
+		return true
+	default:
+		return false
+	}
+}
+
+// resumable returns whether session resumption is enabled.
+func (s *Server) resumable() bool {
+	return s.resumeGracePeriod > 0 && s.resumeMessageID != nil
+}
+
+// resumeSession attaches conn to the session of token.
+// If the session's still owned by an old connection, the old one is kicked out.
+// Returns nil if there's no such session, or the session's closed.
+func (s *Server) resumeSession(token string, conn net.Conn) *session {
+	s.resumablesMu.Lock()
+	sess := s.resumables[token]
+	s.resumablesMu.Unlock()
+	if sess == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(resumeKickInterval)
+	defer ticker.Stop()
+	for acquired := false; !acquired; {
+		_, _, lostC := sess.connection()
+		sess.loseConn(lostC) // kick the old connection out
+		select {
+		case <-sess.closedC:
+			return nil
+		case <-s.stoppedC:
+			return nil
+		case <-ticker.C: // kick again, in case another connection took over meanwhile
+		case sess.owner <- struct{}{}:
+			acquired = true
+		}
+	}
+	if sess.isClosed() {
+		<-sess.owner
+		return nil
+	}
+	sess.attach(conn)
+	return sess
+}
+
+// awaitResume closes the detached session if it's not resumed in grace period.
+func (s *Server) awaitResume(sess *session, resumedC <-chan struct{}) {
+	timer := time.NewTimer(s.resumeGracePeriod)
+	defer timer.Stop()
+	select {
+	case <-resumedC:
+		return
+	case <-timer.C:
+	case <-s.stoppedC:
+	case <-sess.closedC:
+	}
+	select {
+	case sess.owner <- struct{}{}:
+	default:
+		return // being resumed
+	}
+	_log.Tracef("session %s is not resumed in grace period", sess.id)
+	sess.Close()
+	s.closeSession(sess)
+}
+
+func (s *Server) registerResumable(sess *session) {
+	s.resumablesMu.Lock()
+	s.resumables[sess.resumeToken] = sess
+	s.resumablesMu.Unlock()
+}
+
+func (s *Server) unregisterResumable(sess *session) {
+	s.resumablesMu.Lock()
+	delete(s.resumables, sess.resumeToken)
+	s.resumablesMu.Unlock()
+}
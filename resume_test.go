@@ -0,0 +1,218 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newResumableTestServer(t *testing.T, grace time.Duration) (*Server, chan Session, chan Session, chan Session) {
+	server := NewServer(&ServerOption{
+		ResumeGracePeriod: grace,
+		ResumeMessageID:   100,
+		RespQueueSize:     -1,
+		DoNotPrintRoutes:  true,
+	})
+	created, resumed, closed := make(chan Session, 10), make(chan Session, 10), make(chan Session, 10)
+	server.OnSessionCreate = func(sess Session) {
+		created <- sess
+		// the server speaks first, telling the client the token to resume the session
+		sess.AllocateContext().SetResponseMessage(NewMessage(101, []byte(sess.ResumeToken()))).Send()
+	}
+	server.OnSessionResume = func(sess Session) { resumed <- sess }
+	server.OnSessionClose = func(sess Session) { closed <- sess }
+	server.AddRoute(1, func(ctx Context) {
+		ctx.SetResponseMessage(NewMessage(2, ctx.Request().Data()))
+	})
+	server.NotFoundHandler(func(ctx Context) {
+		ctx.SetResponseMessage(NewMessage(404, ctx.Request().Data()))
+	})
+	go func() {
+		assert.ErrorIs(t, server.Run("localhost:0"), ErrServerStopped)
+	}()
+	<-server.acceptingC
+	return server, created, resumed, closed
+}
+
+// dialTestServer connects to server, and returns the resume token sent by server.
+func dialTestServer(t *testing.T, server *Server) (net.Conn, string) {
+	cli, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	greeting, err := server.Packer.Unpack(cli)
+	require.NoError(t, err)
+	require.EqualValues(t, 101, greeting.ID())
+	return cli, string(greeting.Data())
+}
+
+func requestTestServer(t *testing.T, server *Server, cli net.Conn, msg *Message) *Message {
+	reqBytes, err := server.Packer.Pack(msg)
+	require.NoError(t, err)
+	_, err = cli.Write(reqBytes)
+	require.NoError(t, err)
+	respMsg, err := server.Packer.Unpack(cli)
+	require.NoError(t, err)
+	return respMsg
+}
+
+func TestServer_resumeSession(t *testing.T) {
+	t.Run("when session is resumed in grace period", func(t *testing.T) {
+		server, created, resumed, closed := newResumableTestServer(t, time.Second)
+		defer func() { assert.NoError(t, server.Stop()) }()
+
+		cli, token := dialTestServer(t, server)
+		sess := <-created
+		assert.NotEmpty(t, token)
+		respMsg := requestTestServer(t, server, cli, NewMessage(1, []byte("hello")))
+		assert.EqualValues(t, 2, respMsg.ID())
+
+		// connection lost
+		assert.NoError(t, cli.Close())
+		time.Sleep(time.Millisecond * 20)
+		assert.False(t, sess.(*session).isClosed())
+
+		// messages are queued while detached
+		assert.True(t, sess.AllocateContext().SetResponseMessage(NewMessage(3, []byte("queued"))).Send())
+
+		// resume
+		cli, _ = dialTestServer(t, server)
+		defer cli.Close() // nolint
+		temp := <-created
+		reqBytes, err := server.Packer.Pack(NewMessage(100, []byte(token)))
+		require.NoError(t, err)
+		_, err = cli.Write(reqBytes)
+		require.NoError(t, err)
+
+		assert.Equal(t, sess, <-resumed)
+		assert.Equal(t, temp, <-closed) // the session created for the connection is closed
+		respMsg, err = server.Packer.Unpack(cli)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, respMsg.ID())
+		assert.Equal(t, []byte("queued"), respMsg.Data())
+
+		respMsg = requestTestServer(t, server, cli, NewMessage(1, []byte("again")))
+		assert.Equal(t, []byte("again"), respMsg.Data())
+		assert.Equal(t, cli.LocalAddr().String(), sess.RemoteAddr().String())
+		assert.Empty(t, closed)
+	})
+	t.Run("when session is not resumed in grace period", func(t *testing.T) {
+		server, created, _, closed := newResumableTestServer(t, time.Millisecond*20)
+		defer func() { assert.NoError(t, server.Stop()) }()
+
+		cli, token := dialTestServer(t, server)
+		sess := <-created
+		assert.NoError(t, cli.Close())
+
+		assert.Equal(t, sess, <-closed)
+		<-sess.AfterCloseHook()
+		assert.True(t, sess.(*session).isClosed())
+
+		// resume an expired session
+		cli, _ = dialTestServer(t, server)
+		defer cli.Close() // nolint
+		respMsg := requestTestServer(t, server, cli, NewMessage(100, []byte(token)))
+		assert.EqualValues(t, 404, respMsg.ID()) // routed as usual
+		assert.NotEqual(t, sess.ID(), (<-created).ID())
+	})
+	t.Run("when the old connection is still alive", func(t *testing.T) {
+		server, created, resumed, _ := newResumableTestServer(t, time.Second)
+		defer func() { assert.NoError(t, server.Stop()) }()
+
+		cli1, token := dialTestServer(t, server)
+		defer cli1.Close() // nolint
+		sess := <-created
+
+		cli2, _ := dialTestServer(t, server)
+		defer cli2.Close() // nolint
+		reqBytes, err := server.Packer.Pack(NewMessage(100, []byte(token)))
+		require.NoError(t, err)
+		_, err = cli2.Write(reqBytes)
+		require.NoError(t, err)
+		assert.Equal(t, sess, <-resumed)
+
+		_, err = cli1.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF) // kicked out
+
+		respMsg := requestTestServer(t, server, cli2, NewMessage(1, []byte("from cli2")))
+		assert.Equal(t, []byte("from cli2"), respMsg.Data())
+	})
+	t.Run("when the first message is not a resume message", func(t *testing.T) {
+		server, created, resumed, _ := newResumableTestServer(t, time.Second)
+		defer func() { assert.NoError(t, server.Stop()) }()
+
+		cli1, token := dialTestServer(t, server)
+		defer cli1.Close() // nolint
+		<-created
+
+		cli2, _ := dialTestServer(t, server)
+		defer cli2.Close() // nolint
+		requestTestServer(t, server, cli2, NewMessage(1, []byte("hello")))
+		respMsg := requestTestServer(t, server, cli2, NewMessage(100, []byte(token)))
+		assert.EqualValues(t, 404, respMsg.ID()) // routed as usual
+		assert.Empty(t, resumed)
+	})
+	t.Run("when handler of the lost connection is running", func(t *testing.T) {
+		server, created, resumed, _ := newResumableTestServer(t, time.Second)
+		defer func() { assert.NoError(t, server.Stop()) }()
+		var running int32
+		started, release := make(chan struct{}, 10), make(chan struct{})
+		server.AddRoute(5, func(ctx Context) {
+			assert.EqualValues(t, 1, atomic.AddInt32(&running, 1), "handlers of a session run one by one")
+			defer atomic.AddInt32(&running, -1)
+			started <- struct{}{}
+			<-release
+		})
+
+		cli, token := dialTestServer(t, server)
+		sess := <-created
+		reqBytes, err := server.Packer.Pack(NewMessage(5, nil))
+		require.NoError(t, err)
+		_, err = cli.Write(reqBytes)
+		require.NoError(t, err)
+		<-started
+		assert.NoError(t, cli.Close())
+
+		cli, _ = dialTestServer(t, server)
+		defer cli.Close() // nolint
+		<-created
+		resumeBytes, err := server.Packer.Pack(NewMessage(100, []byte(token)))
+		require.NoError(t, err)
+		_, err = cli.Write(resumeBytes)
+		require.NoError(t, err)
+		select {
+		case <-resumed:
+			close(release)
+			t.Fatal("resumed before the handler of the lost connection returns")
+		case <-time.After(time.Millisecond * 50):
+		}
+
+		close(release)
+		assert.Equal(t, sess, <-resumed)
+		_, err = cli.Write(reqBytes)
+		require.NoError(t, err)
+		<-started
+	})
+	t.Run("when server is stopped while detached", func(t *testing.T) {
+		server, created, _, closed := newResumableTestServer(t, time.Minute)
+
+		cli, _ := dialTestServer(t, server)
+		sess := <-created
+		assert.NoError(t, cli.Close())
+		time.Sleep(time.Millisecond * 20)
+
+		assert.NoError(t, server.Stop())
+		assert.Equal(t, sess, <-closed)
+	})
+}
+
+func TestNewServer_resumable(t *testing.T) {
+	assert.False(t, NewServer(&ServerOption{}).resumable())
+	assert.False(t, NewServer(&ServerOption{ResumeGracePeriod: time.Second}).resumable())
+	assert.True(t, NewServer(&ServerOption{ResumeGracePeriod: time.Second, ResumeMessageID: 1}).resumable())
+
+	sess := newSession(nil, &sessionOption{})
+	assert.Empty(t, sess.ResumeToken())
+}
@@ -3,44 +3,69 @@ package easytcp
 import (
 	"fmt"
 	"github.com/olekukonko/tablewriter"
-	"github.com/spf13/cast"
 	"io"
-	"os"
 	"reflect"
-	"runtime"
-	"sort"
-	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 func newRouter() *Router {
-	return &Router{
-		handlerMapper:     make(map[interface{}]HandlerFunc),
-		middlewaresMapper: make(map[interface{}][]MiddlewareFunc),
-	}
+	r := &Router{}
+	r.table.Store(newRouteTable())
+	return r
 }
 
 // Router is a router for incoming message.
 // Router routes the message to its handler and middlewares.
+// Routes can be registered and removed while serving.
 type Router struct {
-	// handlerMapper maps message's ID to handler.
-	// Handler will be called around middlewares.
-	handlerMapper map[interface{}]HandlerFunc
-
-	// middlewaresMapper maps message's ID to a list of middlewares.
-	// These middlewares will be called before the handler in handlerMapper.
-	middlewaresMapper map[interface{}][]MiddlewareFunc
+	// mu serializes the writers of table.
+	mu sync.Mutex
 
-	// globalMiddlewares is a list of MiddlewareFunc.
-	// globalMiddlewares will be called before the ones in middlewaresMapper.
-	globalMiddlewares []MiddlewareFunc
+	// table stores the *routeTable, which is replaced as a whole on every write,
+	// so that handleRequest can read it without locks.
+	table atomic.Value
+}
 
-	notFoundHandler HandlerFunc
+// routeMeta is the extra information of a route.
+type routeMeta struct {
+	name        string       // name of the route, can be empty
+	handlerName string       // overrides the name of the registered HandlerFunc
+	reqType     reflect.Type // type of the request data, can be nil
+	respType    reflect.Type // type of the response data, can be nil
 }
 
 // HandlerFunc is the function type for handlers.
 type HandlerFunc func(ctx Context)
 
+// HandlerFuncE is the function type for handlers which return an error.
+// The error is set to the Context with SetHandlerError.
+type HandlerFuncE func(ctx Context) error
+
+// handlerFuncOf converts h to a HandlerFunc.
+func handlerFuncOf(h HandlerFuncE) HandlerFunc {
+	if h == nil {
+		return nil
+	}
+	return func(ctx Context) {
+		if err := h(ctx); err != nil {
+			ctx.SetHandlerError(err)
+		}
+	}
+}
+
+// ErrorHandlerFunc is the function type for error handlers.
+// It turns the error returned from route handling into a response, or closes the session.
+type ErrorHandlerFunc func(ctx Context, err error)
+
+// defaultErrorHandler logs the error.
+var defaultErrorHandler ErrorHandlerFunc = func(ctx Context, err error) {
+	_log.Errorf("handle message %v err: %s", ctx.Request().ID(), err)
+}
+
 // MiddlewareFunc is the function type for middlewares.
+// It's called once when the routes are compiled, rather than on every message,
+// so per-message states should live in the returned HandlerFunc.
 // A common pattern is like:
 //
 //	var mf MiddlewareFunc = func(next HandlerFunc) HandlerFunc {
@@ -59,71 +84,153 @@ func (r *Router) handleRequest(ctx Context) {
 	if reqMsg == nil {
 		return
 	}
-	var handler HandlerFunc
-	if v, has := r.handlerMapper[reqMsg.ID()]; has {
-		handler = v
-	}
 
-	var mws = r.globalMiddlewares
-	if v, has := r.middlewaresMapper[reqMsg.ID()]; has {
-		mws = append(mws, v...) // append to global ones
+	t := r.routes()
+	version := 0
+	if len(t.versionedRoutes) != 0 && ctx.Session() != nil {
+		version = ctx.Session().ProtocolVersion()
 	}
 
-	// create the handlers stack
-	wrapped := r.wrapHandlers(handler, mws)
+	// call the precompiled handlers stack
+	t.chainOf(reqMsg, version)(ctx)
 
-	// and call the handlers stack
-	wrapped(ctx)
+	if err := ctx.HandlerError(); err != nil {
+		t.handleError(ctx, err)
+	}
 }
 
-// wrapHandlers wraps handler and middlewares into a right order call stack.
-// Makes something like:
-//
-//	var wrapped HandlerFunc = m1(m2(m3(handle)))
-func (r *Router) wrapHandlers(handler HandlerFunc, middles []MiddlewareFunc) (wrapped HandlerFunc) {
-	if handler == nil {
-		handler = r.notFoundHandler
-	}
-	if handler == nil {
-		handler = nilHandler
+// handleOutbound walks the outbound ctx through outbound middlewares.
+func (r *Router) handleOutbound(ctx Context) {
+	t := r.routes()
+	t.compileOnce.Do(t.compile)
+	if t.outboundChain != nil {
+		t.outboundChain(ctx)
 	}
-	wrapped = handler
-	for i := len(middles) - 1; i >= 0; i-- {
-		m := middles[i]
-		wrapped = m(wrapped)
-	}
-	return wrapped
+}
+
+// compile builds the handler chains of current routes ahead of the first request.
+func (r *Router) compile() {
+	t := r.routes()
+	t.compileOnce.Do(t.compile)
+}
+
+// routes returns the current route table, which must not be modified.
+func (r *Router) routes() *routeTable {
+	return r.table.Load().(*routeTable)
+}
+
+// update applies fn to a copy of the route table, and replaces the route table with it.
+func (r *Router) update(fn func(t *routeTable)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.routes().clone()
+	fn(t)
+	r.table.Store(t)
 }
 
 // register stores handler and middlewares for id.
 func (r *Router) register(id interface{}, h HandlerFunc, m ...MiddlewareFunc) {
-	if h != nil {
-		r.handlerMapper[id] = h
+	r.registerInGroup(id, nil, h, m...)
+}
+
+// registerInGroup stores handler and middlewares for id, along with the group middlewares.
+// Panics if id conflicts with a registered one of another type.
+func (r *Router) registerInGroup(id interface{}, groupMiddlewares []MiddlewareFunc, h HandlerFunc, m ...MiddlewareFunc) {
+	rawID, id := id, normalizeID(id)
+	ms := compactMiddlewares(m)
+	if h == nil && len(ms) == 0 {
+		return
 	}
-	ms := make([]MiddlewareFunc, 0, len(m))
-	for _, mm := range m {
-		if mm != nil {
-			ms = append(ms, mm)
+	r.update(func(t *routeTable) {
+		t.setRawID(id, rawID)
+		if h != nil {
+			t.handlerMapper[id] = h
+			delete(t.groupMiddlewaresMapper, id)
+			delete(t.metaMapper, id)
+			if len(groupMiddlewares) != 0 {
+				gms := make([]MiddlewareFunc, len(groupMiddlewares))
+				copy(gms, groupMiddlewares)
+				t.groupMiddlewaresMapper[id] = gms
+			}
 		}
-	}
-	if len(ms) != 0 {
-		r.middlewaresMapper[id] = ms
-	}
+		if len(ms) != 0 {
+			t.middlewaresMapper[id] = ms
+		}
+	})
+}
+
+// replace replaces the route of id with handler and middlewares, the old middlewares are dropped.
+// Panics if id conflicts with a registered one of another type.
+func (r *Router) replace(id interface{}, h HandlerFunc, m ...MiddlewareFunc) {
+	rawID, id := id, normalizeID(id)
+	r.update(func(t *routeTable) {
+		if h == nil {
+			t.remove(id)
+			return
+		}
+		t.setRawID(id, rawID) // checks the conflict before the old route is removed
+		t.remove(id)
+		t.rawIDs[id] = rawID
+		t.handlerMapper[id] = h
+		if ms := compactMiddlewares(m); len(ms) != 0 {
+			t.middlewaresMapper[id] = ms
+		}
+	})
+}
+
+// remove deletes the route of id.
+func (r *Router) remove(id interface{}) {
+	id = normalizeID(id)
+	r.update(func(t *routeTable) {
+		t.remove(id)
+	})
+}
+
+// setRouteMeta stores the extra information of the route of id.
+func (r *Router) setRouteMeta(id interface{}, meta *routeMeta) {
+	id = normalizeID(id)
+	r.update(func(t *routeTable) {
+		t.metaMapper[id] = meta
+	})
+}
+
+// setRouteName sets the name of the route of id, keeping the other information.
+func (r *Router) setRouteName(id interface{}, name string) {
+	id = normalizeID(id)
+	r.update(func(t *routeTable) {
+		meta := &routeMeta{}
+		if v, has := t.metaMapper[id]; has {
+			*meta = *v // copy, since the old table may be in use
+		}
+		meta.name = name
+		t.metaMapper[id] = meta
+	})
 }
 
 // registerMiddleware stores the global middlewares.
 func (r *Router) registerMiddleware(m ...MiddlewareFunc) {
-	for _, mm := range m {
-		if mm != nil {
-			r.globalMiddlewares = append(r.globalMiddlewares, mm)
-		}
+	ms := compactMiddlewares(m)
+	if len(ms) == 0 {
+		return
 	}
+	r.update(func(t *routeTable) {
+		t.globalMiddlewares = append(t.globalMiddlewares, ms...)
+	})
 }
 
-// printHandlers prints registered route handlers to console.
-func (r *Router) printHandlers(addr string) {
-	var w io.Writer = os.Stdout
+// registerOutboundMiddleware stores the outbound middlewares.
+func (r *Router) registerOutboundMiddleware(m ...MiddlewareFunc) {
+	ms := compactMiddlewares(m)
+	if len(ms) == 0 {
+		return
+	}
+	r.update(func(t *routeTable) {
+		t.outboundMiddlewares = append(t.outboundMiddlewares, ms...)
+	})
+}
 
+// printHandlers prints registered route handlers to w.
+func (r *Router) printHandlers(w io.Writer, addr string) {
 	_, _ = fmt.Fprintf(w, "\n[EASYTCP] Message-Route Table:\n")
 	table := tablewriter.NewWriter(w)
 	table.SetHeader([]string{"Message ID", "Route Handler", "Middleware"})
@@ -132,36 +239,10 @@ func (r *Router) printHandlers(addr string) {
 	table.SetRowLine(true)
 	table.SetColumnAlignment([]int{tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_RIGHT, tablewriter.ALIGN_LEFT})
 
-	// sort ids
-	ids := make([]interface{}, 0, len(r.handlerMapper))
-	for id := range r.handlerMapper {
-		ids = append(ids, id)
-	}
-	sort.Slice(ids, func(i, j int) bool {
-		a, b := cast.ToString(ids[i]), cast.ToString(ids[j])
-		return a < b
-	})
-
 	// add table row
-	for _, id := range ids {
-		// route handler
-		h := r.handlerMapper[id]
-		handlerName := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
-
-		middlewareNames := make([]string, 0, len(r.globalMiddlewares)+len(r.middlewaresMapper[id]))
-		// global middleware
-		for _, m := range r.globalMiddlewares {
-			middlewareName := fmt.Sprintf("%s(g)", runtime.FuncForPC(reflect.ValueOf(m).Pointer()).Name())
-			middlewareNames = append(middlewareNames, middlewareName)
-		}
-
-		// route middleware
-		for _, m := range r.middlewaresMapper[id] {
-			middlewareName := runtime.FuncForPC(reflect.ValueOf(m).Pointer()).Name()
-			middlewareNames = append(middlewareNames, middlewareName)
-		}
-
-		table.Append([]string{fmt.Sprintf("%v", id), handlerName, strings.Join(middlewareNames, "\n")})
+	for _, info := range r.routes().routeInfos() {
+		id, handler, middlewares := info.tableRow()
+		table.Append([]string{id, handler, middlewares})
 	}
 
 	table.Render()
@@ -169,5 +250,13 @@ func (r *Router) printHandlers(addr string) {
 }
 
 func (r *Router) setNotFoundHandler(handler HandlerFunc) {
-	r.notFoundHandler = handler
+	r.update(func(t *routeTable) {
+		t.notFoundHandler = handler
+	})
+}
+
+func (r *Router) setErrorHandler(handler ErrorHandlerFunc) {
+	r.update(func(t *routeTable) {
+		t.errorHandler = handler
+	})
 }
@@ -3,6 +3,7 @@ package easytcp
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 )
@@ -14,8 +15,13 @@ type Context interface {
 
 	// WithContext sets the underline context.
 	// It's very useful to control the workflow when send to response channel.
+	// The values of ctx are returned by Value, if not found in storage.
+	// ctx should be derived from RawContext, panics if it's derived from Context itself.
 	WithContext(ctx context.Context) Context
 
+	// RawContext returns the underline context.
+	RawContext() context.Context
+
 	// Session returns the current session.
 	Session() Session
 
@@ -51,9 +57,21 @@ type Context interface {
 	// SetResponseMessage sets response message directly.
 	SetResponseMessage(msg *Message) Context
 
+	// HandlerError returns the error returned from the handler.
+	// Middlewares can check it after calling next.
+	HandlerError() error
+
+	// SetHandlerError sets the error of handling,
+	// which will be passed to the server's error handler after the middlewares.
+	SetHandlerError(err error) Context
+
 	// Send sends itself to current session.
 	Send() bool
 
+	// Stream returns the Stream to send multiple response messages for the request.
+	// Once the Stream's used, the response set to Context is not sent after the handler returns.
+	Stream() Stream
+
 	// SendTo sends itself to session.
 	SendTo(session Session) bool
 
@@ -66,8 +84,19 @@ type Context interface {
 	// Remove deletes the key from storage.
 	Remove(key string)
 
+	// SetValue stores key value into storage, the key can be of any comparable type.
+	// It's returned by Value, like the ones stored by Set.
+	SetValue(key, value interface{}) Context
+
 	// Copy returns a copy of Context.
+	// The copy shares the storage with Context, and is not safe to use after the handler returns.
 	Copy() Context
+
+	// Detach returns a deep copy of Context, which is owned by the caller.
+	// The storage is copied, and the underline context is the session's context carrying the values of Context,
+	// so that it's safe to keep and send after the handler returns.
+	// Detach must be called before the handler returns.
+	Detach() Context
 }
 
 var _ Context = &routeContext{} // implementation check
@@ -83,60 +112,96 @@ func newContext() *routeContext {
 type routeContext struct {
 	rawCtx  context.Context
 	mu      sync.RWMutex
-	storage map[string]interface{}
+	storage map[interface{}]interface{}
 	session Session
 	reqMsg  *Message
 	respMsg *Message
+	err     error
+	stream  *stream
+	pooled  bool         // allocated from the session's ctxPool
+	guard   contextGuard // detects the use after released in debug build
 }
 
 // Deadline implements the context.Context Deadline method.
 func (c *routeContext) Deadline() (time.Time, bool) {
+	c.checkReleased()
 	return c.rawCtx.Deadline()
 }
 
 // Done implements the context.Context Done method.
 func (c *routeContext) Done() <-chan struct{} {
+	c.checkReleased()
 	return c.rawCtx.Done()
 }
 
 // Err implements the context.Context Err method.
 func (c *routeContext) Err() error {
+	c.checkReleased()
 	return c.rawCtx.Err()
 }
 
 // Value implements the context.Context Value method.
+// The value in storage is returned if there's one, otherwise the underline context's.
 func (c *routeContext) Value(key interface{}) interface{} {
-	if keyAsString, ok := key.(string); ok {
-		val, _ := c.Get(keyAsString)
+	c.checkReleased()
+	if k, ok := key.(chainKey); ok {
+		if k.c == c {
+			return c
+		}
+		return c.rawCtx.Value(key)
+	}
+	c.mu.RLock()
+	val, has := c.storage[key]
+	c.mu.RUnlock()
+	if has {
 		return val
 	}
-	return nil
+	return c.rawCtx.Value(key)
 }
 
+// chainKey is the Value key to find out whether a context.Context is chained with c.
+// The value is c if it is, otherwise nil.
+type chainKey struct{ c *routeContext }
+
 // WithContext sets the underline context.
+// Panics if ctx is derived from c, which would make a cycle.
 func (c *routeContext) WithContext(ctx context.Context) Context {
+	c.checkReleased()
+	if ctx != nil && ctx.Value(chainKey{c}) != nil {
+		panic("easytcp: WithContext with a context derived from the Context itself, derive it from RawContext instead")
+	}
 	c.rawCtx = ctx
 	return c
 }
 
+// RawContext implements Context.RawContext method.
+func (c *routeContext) RawContext() context.Context {
+	c.checkReleased()
+	return c.rawCtx
+}
+
 // Session implements Context.Session method.
 func (c *routeContext) Session() Session {
+	c.checkReleased()
 	return c.session
 }
 
 // SetSession sets session.
 func (c *routeContext) SetSession(sess Session) Context {
+	c.checkReleased()
 	c.session = sess
 	return c
 }
 
 // Request implements Context.Request method.
 func (c *routeContext) Request() *Message {
+	c.checkReleased()
 	return c.reqMsg
 }
 
 // SetRequest sets request by id and data.
 func (c *routeContext) SetRequest(id, data interface{}) error {
+	c.checkReleased()
 	codec := c.session.Codec()
 	if codec == nil {
 		return fmt.Errorf("codec is nil")
@@ -151,6 +216,7 @@ func (c *routeContext) SetRequest(id, data interface{}) error {
 
 // MustSetRequest implements Context.MustSetRequest method.
 func (c *routeContext) MustSetRequest(id, data interface{}) Context {
+	c.checkReleased()
 	if err := c.SetRequest(id, data); err != nil {
 		panic(err)
 	}
@@ -159,12 +225,14 @@ func (c *routeContext) MustSetRequest(id, data interface{}) Context {
 
 // SetRequestMessage sets request message.
 func (c *routeContext) SetRequestMessage(msg *Message) Context {
+	c.checkReleased()
 	c.reqMsg = msg
 	return c
 }
 
 // Bind implements Context.Bind method.
 func (c *routeContext) Bind(v interface{}) error {
+	c.checkReleased()
 	if c.session.Codec() == nil {
 		return fmt.Errorf("message codec is nil")
 	}
@@ -173,11 +241,13 @@ func (c *routeContext) Bind(v interface{}) error {
 
 // Response implements Context.Response method.
 func (c *routeContext) Response() *Message {
+	c.checkReleased()
 	return c.respMsg
 }
 
 // SetResponse implements Context.SetResponse method.
 func (c *routeContext) SetResponse(id, data interface{}) error {
+	c.checkReleased()
 	codec := c.session.Codec()
 	if codec == nil {
 		return fmt.Errorf("codec is nil")
@@ -192,6 +262,7 @@ func (c *routeContext) SetResponse(id, data interface{}) error {
 
 // MustSetResponse implements Context.MustSetResponse method.
 func (c *routeContext) MustSetResponse(id, data interface{}) Context {
+	c.checkReleased()
 	if err := c.SetResponse(id, data); err != nil {
 		panic(err)
 	}
@@ -200,22 +271,50 @@ func (c *routeContext) MustSetResponse(id, data interface{}) Context {
 
 // SetResponseMessage implements Context.SetResponseMessage method.
 func (c *routeContext) SetResponseMessage(msg *Message) Context {
+	c.checkReleased()
 	c.respMsg = msg
 	return c
 }
 
+// HandlerError implements Context.HandlerError method.
+func (c *routeContext) HandlerError() error {
+	c.checkReleased()
+	return c.err
+}
+
+// SetHandlerError implements Context.SetHandlerError method.
+func (c *routeContext) SetHandlerError(err error) Context {
+	c.checkReleased()
+	c.err = err
+	return c
+}
+
 // Send implements Context.Send method.
 func (c *routeContext) Send() bool {
+	c.checkReleased()
 	return c.session.Send(c)
 }
 
+// Stream implements Context.Stream method.
+func (c *routeContext) Stream() Stream {
+	c.checkReleased()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream == nil {
+		c.stream = newStream(c)
+	}
+	return c.stream
+}
+
 // SendTo implements Context.SendTo method.
 func (c *routeContext) SendTo(sess Session) bool {
+	c.checkReleased()
 	return sess.Send(c)
 }
 
 // Get implements Context.Get method.
 func (c *routeContext) Get(key string) (value interface{}, exists bool) {
+	c.checkReleased()
 	c.mu.RLock()
 	value, exists = c.storage[key]
 	c.mu.RUnlock()
@@ -224,9 +323,28 @@ func (c *routeContext) Get(key string) (value interface{}, exists bool) {
 
 // Set implements Context.Set method.
 func (c *routeContext) Set(key string, value interface{}) {
+	c.checkReleased()
+	c.set(key, value)
+}
+
+// SetValue implements Context.SetValue method.
+// Panics if key is nil or not comparable, like context.WithValue.
+func (c *routeContext) SetValue(key, value interface{}) Context {
+	c.checkReleased()
+	if key == nil {
+		panic("easytcp: nil key")
+	}
+	if !reflect.TypeOf(key).Comparable() {
+		panic("easytcp: key is not comparable")
+	}
+	c.set(key, value)
+	return c
+}
+
+func (c *routeContext) set(key, value interface{}) {
 	c.mu.Lock()
 	if c.storage == nil {
-		c.storage = make(map[string]interface{})
+		c.storage = make(map[interface{}]interface{})
 	}
 	c.storage[key] = value
 	c.mu.Unlock()
@@ -234,6 +352,7 @@ func (c *routeContext) Set(key string, value interface{}) {
 
 // Remove implements Context.Remove method.
 func (c *routeContext) Remove(key string) {
+	c.checkReleased()
 	c.mu.Lock()
 	delete(c.storage, key)
 	c.mu.Unlock()
@@ -241,13 +360,48 @@ func (c *routeContext) Remove(key string) {
 
 // Copy implements Context.Copy method.
 func (c *routeContext) Copy() Context {
+	c.checkReleased()
 	return &routeContext{
 		rawCtx:  c.rawCtx,
 		storage: c.storage,
 		session: c.session,
 		reqMsg:  c.reqMsg,
 		respMsg: c.respMsg,
+		err:     c.err,
+	}
+}
+
+// Detach implements Context.Detach method.
+func (c *routeContext) Detach() Context {
+	c.checkReleased()
+	detached := c.detach(c.session, c.reqMsg, c.rawCtx)
+	detached.respMsg = c.respMsg
+	detached.err = c.err
+	return detached
+}
+
+// detach returns a deep copy of c with sess, reqMsg and the values of raw, without the response and error.
+// Only the storage is read from c, under the lock,
+// so it can be called while c's used by the handler in another goroutine.
+func (c *routeContext) detach(sess Session, reqMsg *Message, raw context.Context) *routeContext {
+	detached := &routeContext{
+		rawCtx:  context.Background(),
+		session: sess,
+		reqMsg:  reqMsg,
+	}
+	if sess != nil {
+		detached.rawCtx = sess.Context()
+	}
+	detached.rawCtx = valuesContext{Context: detached.rawCtx, values: raw}
+	c.mu.RLock()
+	if c.storage != nil {
+		detached.storage = make(map[interface{}]interface{}, len(c.storage))
+		for k, v := range c.storage {
+			detached.storage[k] = v
+		}
 	}
+	c.mu.RUnlock()
+	return detached
 }
 
 func (c *routeContext) reset() {
@@ -256,4 +410,18 @@ func (c *routeContext) reset() {
 	c.reqMsg = nil
 	c.respMsg = nil
 	c.storage = nil
+	c.err = nil
+	c.stream = nil
+	c.pooled = false
+}
+
+// valuesContext is a context.Context which has the values of another one.
+type valuesContext struct {
+	context.Context
+	values context.Context
+}
+
+// Value implements the context.Context Value method.
+func (c valuesContext) Value(key interface{}) interface{} {
+	return c.values.Value(key)
 }
@@ -0,0 +1,27 @@
+//go:build easytcp_debug
+// +build easytcp_debug
+
+package easytcp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// contextGuard marks a pooled context released in debug build.
+type contextGuard struct {
+	released int32
+}
+
+// checkReleased panics if c is released to pool.
+func (c *routeContext) checkReleased() {
+	if atomic.LoadInt32(&c.guard.released) == 1 {
+		panic("easytcp: Context is used after released to pool, use Context.Detach to keep it after the handler returns")
+	}
+}
+
+// release marks c released.
+// c is not put back to pool, so that the use of it afterwards keeps being detected.
+func (c *routeContext) release(_ *sync.Pool) {
+	atomic.StoreInt32(&c.guard.released, 1)
+}
@@ -0,0 +1,26 @@
+//go:build easytcp_debug
+// +build easytcp_debug
+
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_routeContext_useAfterReleased(t *testing.T) {
+	sess := newSession(nil, &sessionOption{Packer: NewDefaultPacker()})
+	ctx := sess.AllocateContext().SetResponseMessage(NewMessage(1, []byte("test")))
+	detached := ctx.Detach()
+
+	_, err := sess.packResponse(ctx)
+	assert.NoError(t, err)
+	assert.PanicsWithValue(t, "easytcp: Context is used after released to pool, use Context.Detach to keep it after the handler returns", func() {
+		ctx.Get("key")
+	})
+	assert.Panics(t, func() { ctx.Send() })
+	assert.NotPanics(t, func() { detached.Get("key") })
+
+	// a released context is never reused
+	assert.NotSame(t, ctx, sess.AllocateContext())
+}
@@ -0,0 +1,19 @@
+//go:build !easytcp_debug
+// +build !easytcp_debug
+
+package easytcp
+
+import (
+	"sync"
+)
+
+// contextGuard is empty in normal build.
+type contextGuard struct{}
+
+// checkReleased does nothing in normal build.
+func (c *routeContext) checkReleased() {}
+
+// release puts c back to pool for reuse.
+func (c *routeContext) release(pool *sync.Pool) {
+	pool.Put(c)
+}
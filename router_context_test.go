@@ -43,6 +43,59 @@ func Test_routeContext_Value(t *testing.T) {
 	assert.Nil(t, c.Value(123))
 }
 
+func Test_routeContext_Value_chaining(t *testing.T) {
+	type ctxKey struct{}
+	c := newTestContext(nil, nil)
+	c.WithContext(context.WithValue(context.Background(), ctxKey{}, "from raw"))
+	assert.Equal(t, "from raw", c.Value(ctxKey{}))
+
+	assert.Equal(t, c, c.SetValue(ctxKey{}, "from storage"))
+	assert.Equal(t, "from storage", c.Value(ctxKey{}))
+	c.SetValue(1, "int key")
+	assert.Equal(t, "int key", c.Value(1))
+	c.SetValue("str", "string key")
+	v, ok := c.Get("str")
+	assert.True(t, ok)
+	assert.Equal(t, "string key", v)
+
+	assert.PanicsWithValue(t, "easytcp: nil key", func() { c.SetValue(nil, 1) })
+	assert.PanicsWithValue(t, "easytcp: key is not comparable", func() { c.SetValue([]int{1}, 1) })
+}
+
+func Test_routeContext_RawContext(t *testing.T) {
+	ctx := newContext()
+	assert.Equal(t, context.Background(), ctx.RawContext())
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx.WithContext(c)
+	assert.Equal(t, c, ctx.RawContext())
+}
+
+func Test_routeContext_WithContext_derived(t *testing.T) {
+	type ctxKey struct{ name string }
+	sess := newSession(nil, &sessionOption{})
+	c := sess.AllocateContext().(*routeContext)
+	c.WithContext(context.WithValue(c.RawContext(), ctxKey{"base"}, "base"))
+
+	c.WithContext(context.WithValue(c.RawContext(), ctxKey{"k1"}, "v1"))
+	assert.Equal(t, "v1", c.Value(ctxKey{"k1"}))
+	assert.Equal(t, "base", c.Value(ctxKey{"base"}))
+	assert.Nil(t, c.Value("missing"))
+
+	// derived from c itself
+	assert.Panics(t, func() { c.WithContext(context.WithValue(c, ctxKey{"k2"}, "v2")) })
+	c2 := newContext()
+	c2.WithContext(c)
+	assert.Panics(t, func() { c.WithContext(c2) }) // c -> c2 -> c
+	assert.Equal(t, "v1", c.Value(ctxKey{"k1"}))
+
+	// derived from another Context
+	other := sess.AllocateContext()
+	other.WithContext(context.WithValue(c, ctxKey{"k2"}, "v2"))
+	assert.Equal(t, "v2", other.Value(ctxKey{"k2"}))
+	assert.Equal(t, "v1", other.Value(ctxKey{"k1"}))
+}
+
 func Test_routeContext_Get(t *testing.T) {
 	c := newTestContext(nil, nil)
 	v, ok := c.Get("not found")
@@ -170,12 +223,24 @@ func Test_routeContext_reset(t *testing.T) {
 	sess := newSession(nil, &sessionOption{})
 	reqMsg := NewMessage(1, []byte("test"))
 	ctx := newTestContext(sess, reqMsg)
+	ctx.SetHandlerError(fmt.Errorf("some err"))
+	ctx.Stream()
 	ctx.reset()
 	assert.Equal(t, ctx.rawCtx, context.Background())
 	assert.Nil(t, ctx.session)
 	assert.Nil(t, ctx.reqMsg)
 	assert.Nil(t, ctx.respMsg)
 	assert.Empty(t, ctx.storage)
+	assert.NoError(t, ctx.err)
+	assert.Nil(t, ctx.stream)
+}
+
+func Test_routeContext_HandlerError(t *testing.T) {
+	ctx := newTestContext(nil, nil)
+	assert.NoError(t, ctx.HandlerError())
+	assert.Equal(t, ctx, ctx.SetHandlerError(fmt.Errorf("some err")))
+	assert.EqualError(t, ctx.HandlerError(), "some err")
+	assert.EqualError(t, ctx.Copy().HandlerError(), "some err")
 }
 
 func Test_routeContext_Copy(t *testing.T) {
@@ -191,6 +256,32 @@ func Test_routeContext_Copy(t *testing.T) {
 	assert.Equal(t, ctx2.Response().Data(), []byte("resp copy"))
 }
 
+func Test_routeContext_Detach(t *testing.T) {
+	sess := newSession(nil, &sessionOption{})
+	ctx := sess.AllocateContext().SetRequestMessage(NewMessage(1, []byte("req")))
+	ctx.Set("key", "value")
+	type ctxKey struct{}
+	ctx.WithContext(context.WithValue(context.TODO(), ctxKey{}, "raw value"))
+
+	detached := ctx.Detach()
+	detached.Set("key", "detached value")
+	ctx.Remove("key")
+
+	v, ok := detached.Get("key")
+	assert.True(t, ok)
+	assert.Equal(t, "detached value", v)
+	_, ok = ctx.Get("key")
+	assert.False(t, ok)
+	assert.Equal(t, ctx.Request(), detached.Request())
+	assert.Equal(t, sess, detached.Session())
+	assert.Equal(t, "raw value", detached.Value(ctxKey{}))
+	sess.Close()
+	assert.ErrorIs(t, detached.Err(), context.Canceled) // done along with the session
+	assert.False(t, detached.(*routeContext).pooled)
+
+	assert.Nil(t, newContext().Detach().(*routeContext).storage)
+}
+
 func Test_routeContext_MustSetResponse(t *testing.T) {
 	t.Run("when session hasn't codec", func(t *testing.T) {
 		reqMsg := NewMessage(1, []byte("test"))
@@ -0,0 +1,59 @@
+package easytcp
+
+// RouteGroup is a group of routes sharing the same middlewares.
+type RouteGroup struct {
+	router      *Router
+	middlewares []MiddlewareFunc
+}
+
+// AddRoute registers message handler and middlewares to the router.
+// The group middlewares will be called before the route middlewares.
+func (g *RouteGroup) AddRoute(msgID interface{}, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	g.router.registerInGroup(msgID, g.middlewares, handler, middlewares...)
+}
+
+// AddRouteE registers message handler which returns an error, and middlewares to the router.
+// The group middlewares will be called before the route middlewares.
+func (g *RouteGroup) AddRouteE(msgID interface{}, handler HandlerFuncE, middlewares ...MiddlewareFunc) {
+	g.router.registerInGroup(msgID, g.middlewares, handlerFuncOf(handler), middlewares...)
+}
+
+// AddVersionedRoute registers message handler and middlewares for msgID of the protocol versions in [minVersion, maxVersion].
+// The group middlewares will be called before the route middlewares.
+func (g *RouteGroup) AddVersionedRoute(msgID interface{}, minVersion, maxVersion int, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	g.router.registerVersioned(msgID, minVersion, maxVersion, g.middlewares, handler, middlewares...)
+}
+
+// AddRangeRoute registers message handler and middlewares for the message IDs in [fromID, toID].
+// The group middlewares will be called before the route middlewares.
+func (g *RouteGroup) AddRangeRoute(fromID, toID int64, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	g.router.registerRange(fromID, toID, g.middlewares, handler, middlewares...)
+}
+
+// AddMatchRoute registers message handler and middlewares for the messages which match reports true.
+// The group middlewares will be called before the route middlewares.
+func (g *RouteGroup) AddMatchRoute(match MatchFunc, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	g.router.registerMatch(match, g.middlewares, handler, middlewares...)
+}
+
+// Use registers middlewares to the group.
+// The middlewares only apply to the routes added after.
+func (g *RouteGroup) Use(middlewares ...MiddlewareFunc) {
+	for _, m := range middlewares {
+		if m != nil {
+			g.middlewares = append(g.middlewares, m)
+		}
+	}
+}
+
+// Group creates a nested RouteGroup.
+// Routes in the nested group will be applied with current group middlewares first, then middlewares.
+func (g *RouteGroup) Group(middlewares ...MiddlewareFunc) *RouteGroup {
+	nested := &RouteGroup{
+		router:      g.router,
+		middlewares: make([]MiddlewareFunc, len(g.middlewares), len(g.middlewares)+len(middlewares)),
+	}
+	copy(nested.middlewares, g.middlewares)
+	nested.Use(middlewares...)
+	return nested
+}
@@ -0,0 +1,65 @@
+package easytcp
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestServer_Group(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	var result []string
+	newMiddleware := func(name string) MiddlewareFunc {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx Context) {
+				result = append(result, name)
+				next(ctx)
+			}
+		}
+	}
+	handler := func(ctx Context) { result = append(result, "handler") }
+
+	s.Use(newMiddleware("global"))
+	g := s.Group(newMiddleware("g1"), nil)
+	g.AddRoute(1, handler, newMiddleware("route"))
+	g.Use(newMiddleware("g2")) // only applies to routes added after
+	g.AddRoute(2, handler)
+	nested := g.Group(newMiddleware("nested"))
+	nested.AddRoute(3, handler)
+	g.Use(newMiddleware("g3")) // doesn't affect the nested group
+	nested.AddRoute(4, handler)
+
+	expects := map[int][]string{
+		1: {"global", "g1", "route", "handler"},
+		2: {"global", "g1", "g2", "handler"},
+		3: {"global", "g1", "g2", "nested", "handler"},
+		4: {"global", "g1", "g2", "nested", "handler"},
+	}
+	for id, expect := range expects {
+		result = nil
+		s.router.handleRequest(&routeContext{reqMsg: NewMessage(id, nil)})
+		assert.Equal(t, expect, result, "route %d", id)
+	}
+
+	// registered again outside the group
+	s.AddRoute(1, handler)
+	result = nil
+	s.router.handleRequest(&routeContext{reqMsg: NewMessage(1, nil)})
+	assert.Equal(t, []string{"global", "route", "handler"}, result)
+
+	s.router.printHandlers(os.Stdout, "localhost")
+}
+
+func TestRouteGroup_AddRouteE(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	var handled error
+	s.ErrorHandler(func(ctx Context, err error) { handled = err })
+	s.AddRouteE(1, func(ctx Context) error { return fmt.Errorf("err 1") })
+	s.Group().AddRouteE(2, func(ctx Context) error { return fmt.Errorf("err 2") })
+
+	s.router.handleRequest(&routeContext{reqMsg: NewMessage(1, nil)})
+	assert.EqualError(t, handled, "err 1")
+	s.router.handleRequest(&routeContext{reqMsg: NewMessage(2, nil)})
+	assert.EqualError(t, handled, "err 2")
+}
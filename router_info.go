@@ -0,0 +1,171 @@
+package easytcp
+
+import (
+	"fmt"
+	"github.com/spf13/cast"
+	"reflect"
+	"runtime"
+	"sort"
+)
+
+// Scopes of MiddlewareInfo.
+const (
+	MiddlewareScopeGlobal = "global" // registered by Server.Use
+	MiddlewareScopeGroup  = "group"  // registered by Server.Group or RouteGroup.Use
+	MiddlewareScopeRoute  = "route"  // registered along with the route
+)
+
+// RouteInfo describes a registered route.
+// Exactly one of ID, IDRange and Matcher is set, and Versions is only set along with ID.
+type RouteInfo struct {
+	ID           interface{}      `json:"id,omitempty"`            // message ID of the exact route, as registered
+	IDRange      []int64          `json:"id_range,omitempty"`      // [from, to] message IDs of the range route
+	Versions     []int            `json:"versions,omitempty"`      // [min, max] protocol versions of the versioned route
+	Matcher      string           `json:"matcher,omitempty"`       // name of the MatchFunc of the match route
+	Name         string           `json:"name,omitempty"`          // name set by Server.SetRouteName
+	Handler      string           `json:"handler"`                 // name of the handler function
+	Middlewares  []MiddlewareInfo `json:"middlewares"`             // middlewares in the calling order
+	RequestType  string           `json:"request_type,omitempty"`  // type of request data, only known for typed routes
+	ResponseType string           `json:"response_type,omitempty"` // type of response data, only known for typed routes
+}
+
+// MiddlewareInfo describes a middleware of a route.
+type MiddlewareInfo struct {
+	Name  string `json:"name"`  // name of the middleware function
+	Scope string `json:"scope"` // one of MiddlewareScopeGlobal, MiddlewareScopeGroup and MiddlewareScopeRoute
+}
+
+// routeInfos returns the information of the routes in t, in the order of looking up.
+// Exact routes are sorted by ID, integer IDs first, and the versioned ones come first for the same ID.
+func (t *routeTable) routeInfos() []RouteInfo {
+	infos := make([]RouteInfo, 0, len(t.handlerMapper)+len(t.versionedRoutes)+len(t.rangeRoutes)+len(t.matchRoutes))
+
+	// sort ids
+	ids := make([]interface{}, 0, len(t.rawIDs))
+	for id := range t.rawIDs {
+		if _, has := t.handlerMapper[id]; has || len(t.versionedRoutes[id]) != 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, aIsInt := integerID(ids[i])
+		b, bIsInt := integerID(ids[j])
+		if aIsInt && bIsInt {
+			return a < b
+		}
+		if aIsInt != bIsInt {
+			return aIsInt
+		}
+		return cast.ToString(ids[i]) < cast.ToString(ids[j])
+	})
+
+	for _, id := range ids {
+		vrs := append([]*versionedRoute(nil), t.versionedRoutes[id]...)
+		sort.Slice(vrs, func(i, j int) bool { return vrs[i].minVersion < vrs[j].minVersion })
+		for _, vr := range vrs {
+			infos = append(infos, RouteInfo{
+				ID:          t.rawIDs[id],
+				Versions:    []int{vr.minVersion, vr.maxVersion},
+				Handler:     funcName(vr.handler),
+				Middlewares: t.middlewareInfos(vr.groupMiddlewares, vr.middlewares),
+			})
+		}
+
+		if _, has := t.handlerMapper[id]; !has {
+			continue
+		}
+		info := RouteInfo{
+			ID:          t.rawIDs[id],
+			Handler:     funcName(t.handlerMapper[id]),
+			Middlewares: t.middlewareInfos(t.groupMiddlewaresMapper[id], t.middlewaresMapper[id]),
+		}
+		if meta, has := t.metaMapper[id]; has {
+			info.Name = meta.name
+			if meta.handlerName != "" {
+				info.Handler = meta.handlerName
+			}
+			if meta.reqType != nil {
+				info.RequestType = meta.reqType.String()
+			}
+			if meta.respType != nil {
+				info.ResponseType = meta.respType.String()
+			}
+		}
+		infos = append(infos, info)
+	}
+
+	for _, rr := range t.rangeRoutes {
+		infos = append(infos, RouteInfo{
+			IDRange:     []int64{rr.from, rr.to},
+			Handler:     funcName(rr.handler),
+			Middlewares: t.middlewareInfos(rr.groupMiddlewares, rr.middlewares),
+		})
+	}
+
+	for _, mr := range t.matchRoutes {
+		infos = append(infos, RouteInfo{
+			Matcher:     funcName(mr.match),
+			Handler:     funcName(mr.handler),
+			Middlewares: t.middlewareInfos(mr.groupMiddlewares, mr.middlewares),
+		})
+	}
+	return infos
+}
+
+// middlewareInfos returns the information of global, group and route middlewares of a route.
+func (t *routeTable) middlewareInfos(groupMws, routeMws []MiddlewareFunc) []MiddlewareInfo {
+	infos := make([]MiddlewareInfo, 0, len(t.globalMiddlewares)+len(groupMws)+len(routeMws))
+	for _, m := range t.globalMiddlewares {
+		infos = append(infos, MiddlewareInfo{Name: funcName(m), Scope: MiddlewareScopeGlobal})
+	}
+	for _, m := range groupMws {
+		infos = append(infos, MiddlewareInfo{Name: funcName(m), Scope: MiddlewareScopeGroup})
+	}
+	for _, m := range routeMws {
+		infos = append(infos, MiddlewareInfo{Name: funcName(m), Scope: MiddlewareScopeRoute})
+	}
+	return infos
+}
+
+// tableRow returns the cells of info in the route table printed by printHandlers.
+func (info *RouteInfo) tableRow() (id, handler, middlewares string) {
+	switch {
+	case info.IDRange != nil:
+		id = fmt.Sprintf("%d-%d", info.IDRange[0], info.IDRange[1])
+	case info.Matcher != "":
+		id = fmt.Sprintf("match(%s)", info.Matcher)
+	default:
+		id = fmt.Sprintf("%v", info.ID)
+	}
+	if info.Versions != nil {
+		id = fmt.Sprintf("%s\nv%d-%d", id, info.Versions[0], info.Versions[1])
+	}
+	if info.Name != "" {
+		id = fmt.Sprintf("%s\n(%s)", id, info.Name)
+	}
+
+	handler = info.Handler
+	if info.RequestType != "" || info.ResponseType != "" {
+		handler = fmt.Sprintf("%s\n%s -> %s", handler, info.RequestType, info.ResponseType)
+	}
+
+	for i, m := range info.Middlewares {
+		if i > 0 {
+			middlewares += "\n"
+		}
+		switch m.Scope {
+		case MiddlewareScopeGlobal:
+			middlewares += fmt.Sprintf("%s(g)", m.Name)
+		case MiddlewareScopeGroup:
+			middlewares += fmt.Sprintf("%s(group)", m.Name)
+		default:
+			middlewares += m.Name
+		}
+	}
+	return
+}
+
+// funcName returns the name of function fn.
+func funcName(fn interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+}
@@ -0,0 +1,90 @@
+package easytcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func routeInfoTestMiddleware(next HandlerFunc) HandlerFunc { return next }
+
+func routeInfoTestHandler(_ Context) {}
+
+func routeInfoTestMatch(_ *Message) bool { return false }
+
+func TestServer_Routes(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	s.Use(routeInfoTestMiddleware)
+	s.AddRoute("b", routeInfoTestHandler)
+	s.AddRoute(uint16(10), routeInfoTestHandler, routeInfoTestMiddleware)
+	s.Group(routeInfoTestMiddleware).AddRoute(9, routeInfoTestHandler)
+	s.AddRoute("a", routeInfoTestHandler)
+	AddTypedRoute(s, 11, 12, typedTestHandler)
+	s.AddRangeRoute(1000, 1999, routeInfoTestHandler)
+	s.AddMatchRoute(routeInfoTestMatch, routeInfoTestHandler)
+	s.SetRouteName(10, "login")
+
+	routes := s.Routes()
+	assert.Len(t, routes, 7)
+
+	// sorted by id, integer ids first
+	var ids []interface{}
+	for _, r := range routes[:5] {
+		ids = append(ids, r.ID)
+	}
+	assert.Equal(t, []interface{}{9, uint16(10), 11, "a", "b"}, ids)
+
+	assert.Equal(t, RouteInfo{
+		ID:      9,
+		Handler: "github.com/DarthPestilane/easytcp.routeInfoTestHandler",
+		Middlewares: []MiddlewareInfo{
+			{Name: "github.com/DarthPestilane/easytcp.routeInfoTestMiddleware", Scope: MiddlewareScopeGlobal},
+			{Name: "github.com/DarthPestilane/easytcp.routeInfoTestMiddleware", Scope: MiddlewareScopeGroup},
+		},
+	}, routes[0])
+	assert.Equal(t, "login", routes[1].Name)
+	assert.Equal(t, MiddlewareScopeRoute, routes[1].Middlewares[1].Scope)
+	assert.Equal(t, "github.com/DarthPestilane/easytcp.typedTestHandler", routes[2].Handler)
+	assert.Equal(t, "*easytcp.typedTestReq", routes[2].RequestType)
+	assert.Equal(t, "*easytcp.typedTestResp", routes[2].ResponseType)
+	assert.Equal(t, []int64{1000, 1999}, routes[5].IDRange)
+	assert.Nil(t, routes[5].ID)
+	assert.Equal(t, "github.com/DarthPestilane/easytcp.routeInfoTestMatch", routes[6].Matcher)
+
+	// name is kept along with types
+	s.SetRouteName(11, "typed")
+	assert.Equal(t, "typed", s.Routes()[2].Name)
+	assert.Equal(t, "*easytcp.typedTestReq", s.Routes()[2].RequestType)
+
+	data, err := s.RoutesJSON()
+	assert.NoError(t, err)
+	var decoded []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Len(t, decoded, 7)
+	assert.EqualValues(t, 10, decoded[1]["id"])
+	assert.Equal(t, "login", decoded[1]["name"])
+	assert.NotContains(t, decoded[1], "request_type")
+	assert.Equal(t, []interface{}{float64(1000), float64(1999)}, decoded[5]["id_range"])
+}
+
+func TestRouter_printHandlers_writer(t *testing.T) {
+	buf := &bytes.Buffer{}
+	s := NewServer(&ServerOption{RoutesWriter: buf})
+	s.AddRoute(1, routeInfoTestHandler, routeInfoTestMiddleware)
+	s.SetRouteName(1, "ping")
+	s.AddRangeRoute(1000, 1999, routeInfoTestHandler)
+	s.AddMatchRoute(routeInfoTestMatch, routeInfoTestHandler)
+	go func() {
+		assert.ErrorIs(t, s.Run("localhost:0"), ErrServerStopped)
+	}()
+	<-s.acceptingC
+	assert.NoError(t, s.Stop())
+
+	out := buf.String()
+	assert.Contains(t, out, "Message-Route Table")
+	assert.Contains(t, out, "(ping)")
+	assert.Contains(t, out, "1000-1999")
+	assert.Contains(t, out, "match(github.com/DarthPestilane/easytcp.routeInfoTestMatch)")
+	assert.Contains(t, out, "Serving at: tcp://")
+}
@@ -0,0 +1,102 @@
+package easytcp
+
+import (
+	"fmt"
+)
+
+// MatchFunc is the function type for route matchers.
+// It reports whether the message should be routed to the handler.
+type MatchFunc func(msg *Message) bool
+
+// rangeRoute routes the messages whose ID is in [from, to].
+type rangeRoute struct {
+	from, to         int64
+	handler          HandlerFunc
+	groupMiddlewares []MiddlewareFunc
+	middlewares      []MiddlewareFunc
+}
+
+// matchRoute routes the messages which match reports true.
+type matchRoute struct {
+	match            MatchFunc
+	handler          HandlerFunc
+	groupMiddlewares []MiddlewareFunc
+	middlewares      []MiddlewareFunc
+}
+
+// registerRange stores handler and middlewares for the IDs in [from, to].
+// Panics if from is greater than to.
+func (r *Router) registerRange(from, to int64, groupMiddlewares []MiddlewareFunc, h HandlerFunc, m ...MiddlewareFunc) {
+	if from > to {
+		panic(fmt.Sprintf("easytcp: invalid message ID range [%d, %d]", from, to))
+	}
+	if h == nil {
+		return
+	}
+	rr := &rangeRoute{
+		from:             from,
+		to:               to,
+		handler:          h,
+		groupMiddlewares: compactMiddlewares(groupMiddlewares),
+		middlewares:      compactMiddlewares(m),
+	}
+	r.update(func(t *routeTable) {
+		t.rangeRoutes = append(t.rangeRoutes, rr)
+	})
+}
+
+// registerMatch stores handler and middlewares for the messages matched by match.
+func (r *Router) registerMatch(match MatchFunc, groupMiddlewares []MiddlewareFunc, h HandlerFunc, m ...MiddlewareFunc) {
+	if match == nil || h == nil {
+		return
+	}
+	mr := &matchRoute{
+		match:            match,
+		handler:          h,
+		groupMiddlewares: compactMiddlewares(groupMiddlewares),
+		middlewares:      compactMiddlewares(m),
+	}
+	r.update(func(t *routeTable) {
+		t.matchRoutes = append(t.matchRoutes, mr)
+	})
+}
+
+// matchRange returns the index of the first registered range route containing id.
+// Returns -1 if id is not numeric or no range contains it.
+func (t *routeTable) matchRange(id interface{}) int {
+	if len(t.rangeRoutes) == 0 {
+		return -1
+	}
+	n, ok := integerID(id)
+	if !ok {
+		return -1
+	}
+	for i, rr := range t.rangeRoutes {
+		if n >= rr.from && n <= rr.to {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchPredicate returns the index of the first registered match route which matches msg.
+// Returns -1 if there's no match.
+func (t *routeTable) matchPredicate(msg *Message) int {
+	for i, mr := range t.matchRoutes {
+		if mr.match(msg) {
+			return i
+		}
+	}
+	return -1
+}
+
+// compactMiddlewares returns a copy of m without nil ones.
+func compactMiddlewares(m []MiddlewareFunc) []MiddlewareFunc {
+	ms := make([]MiddlewareFunc, 0, len(m))
+	for _, mm := range m {
+		if mm != nil {
+			ms = append(ms, mm)
+		}
+	}
+	return ms
+}
@@ -0,0 +1,77 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestRouter_registerRange(t *testing.T) {
+	rt := newRouter()
+	assert.Panics(t, func() { rt.registerRange(2, 1, nil, nilHandler) })
+
+	rt.registerRange(1, 2, nil, nil)
+	assert.Empty(t, rt.routes().rangeRoutes)
+
+	rt.registerRange(1, 2, nil, nilHandler, nil)
+	assert.Len(t, rt.routes().rangeRoutes, 1)
+	assert.Empty(t, rt.routes().rangeRoutes[0].middlewares)
+}
+
+func TestRouter_registerMatch(t *testing.T) {
+	rt := newRouter()
+	rt.registerMatch(nil, nil, nilHandler)
+	rt.registerMatch(func(msg *Message) bool { return true }, nil, nil)
+	assert.Empty(t, rt.routes().matchRoutes)
+
+	rt.registerMatch(func(msg *Message) bool { return true }, nil, nilHandler)
+	assert.Len(t, rt.routes().matchRoutes, 1)
+}
+
+func TestRouter_handleReq_rangeAndMatch(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	var result []string
+	newHandler := func(name string) HandlerFunc {
+		return func(ctx Context) { result = append(result, name) }
+	}
+	newMiddleware := func(name string) MiddlewareFunc {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx Context) {
+				result = append(result, name)
+				next(ctx)
+			}
+		}
+	}
+
+	s.Use(newMiddleware("global"))
+	s.AddRoute(1500, newHandler("exact"))
+	s.AddRangeRoute(1000, 1999, newHandler("admin"), newMiddleware("range"))
+	s.AddRangeRoute(1000, 2999, newHandler("wide"))
+	s.Group(newMiddleware("group")).AddRangeRoute(3000, 3999, newHandler("grouped"))
+	s.AddMatchRoute(func(msg *Message) bool { return string(msg.Data()) == "forward" }, newHandler("forward"), newMiddleware("match"))
+	s.Group(newMiddleware("group")).AddMatchRoute(func(msg *Message) bool { return msg.ID() == "name" }, newHandler("named"))
+	s.NotFoundHandler(newHandler("not found"))
+
+	cases := []struct {
+		msg    *Message
+		expect []string
+	}{
+		{NewMessage(1500, []byte("forward")), []string{"global", "exact"}},
+		{NewMessage(1000, nil), []string{"global", "range", "admin"}},
+		{NewMessage(uint16(1999), nil), []string{"global", "range", "admin"}},
+		{NewMessage(2000, nil), []string{"global", "wide"}},
+		{NewMessage(3000, nil), []string{"global", "group", "grouped"}},
+		{NewMessage(4000, []byte("forward")), []string{"global", "match", "forward"}},
+		{NewMessage("name", nil), []string{"global", "group", "named"}},
+		{NewMessage("1500", nil), []string{"global", "not found"}},
+		{NewMessage(uint64(math.MaxUint64), nil), []string{"global", "not found"}},
+	}
+	for _, c := range cases {
+		result = nil
+		s.router.handleRequest(&routeContext{reqMsg: c.msg})
+		assert.Equal(t, c.expect, result, "message %v", c.msg.ID())
+	}
+
+	s.router.printHandlers(os.Stdout, "localhost")
+}
@@ -0,0 +1,271 @@
+package easytcp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// maxFastRouteID is the upper bound (exclusive) of the int IDs
+// whose chains are indexed by a slice instead of a map.
+const maxFastRouteID = 4096
+
+// routeTable is a snapshot of the registered routes.
+// It's never modified once stored in Router, writers clone it, modify the clone, and store the clone.
+type routeTable struct {
+	// handlerMapper maps message's ID to handler.
+	// Handler will be called around middlewares.
+	handlerMapper map[interface{}]HandlerFunc
+
+	// middlewaresMapper maps message's ID to a list of middlewares.
+	// These middlewares will be called before the handler in handlerMapper.
+	middlewaresMapper map[interface{}][]MiddlewareFunc
+
+	// groupMiddlewaresMapper maps message's ID to the middlewares of its RouteGroup.
+	// These middlewares will be called before the ones in middlewaresMapper.
+	groupMiddlewaresMapper map[interface{}][]MiddlewareFunc
+
+	// globalMiddlewares is a list of MiddlewareFunc.
+	// globalMiddlewares will be called before the ones in groupMiddlewaresMapper.
+	globalMiddlewares []MiddlewareFunc
+
+	// versionedRoutes maps message's ID to the routes of protocol version ranges.
+	// They're looked up before handlerMapper.
+	versionedRoutes map[interface{}][]*versionedRoute
+
+	// rangeRoutes routes the messages by ID ranges, in the order of registration.
+	// They're looked up when there's no handler in handlerMapper.
+	rangeRoutes []*rangeRoute
+
+	// matchRoutes routes the messages by matchers, in the order of registration.
+	// They're looked up when there's no handler in handlerMapper nor rangeRoutes.
+	matchRoutes []*matchRoute
+
+	// metaMapper maps message's ID to the extra information of the route, used in printHandlers.
+	metaMapper map[interface{}]*routeMeta
+
+	// rawIDs maps the normalized message's ID to the ID as registered.
+	// The IDs in the maps above are all normalized by normalizeID.
+	rawIDs map[interface{}]interface{}
+
+	// filters is a list of FilterFunc for the inbound messages.
+	filters []FilterFunc
+
+	// outboundMiddlewares is a list of MiddlewareFunc for the outbound contexts.
+	outboundMiddlewares []MiddlewareFunc
+
+	notFoundHandler HandlerFunc
+
+	// timeouts maps message's ID to the handler timeout of the route.
+	timeouts map[interface{}]time.Duration
+
+	// handlerTimeout is the timeout of the handlers which have no timeout in timeouts.
+	handlerTimeout time.Duration
+
+	// timeoutHandler sets the response when a handler times out.
+	timeoutHandler HandlerFunc
+
+	// errorHandler handles the errors returned from route handling.
+	errorHandler ErrorHandlerFunc
+
+	// the handler chains below are built from the routes above by compile, only once.
+	compileOnce     sync.Once
+	fastChains      []HandlerFunc                 // chains of the exact routes with int ID in [0, maxFastRouteID)
+	chains          map[interface{}]HandlerFunc   // chains of the other exact routes
+	rangeChains     []HandlerFunc                 // chains of rangeRoutes
+	matchChains     []HandlerFunc                 // chains of matchRoutes
+	notFoundChains  map[interface{}]HandlerFunc   // chains of not found IDs which have middlewares
+	versionedChains map[interface{}][]HandlerFunc // chains of versionedRoutes
+	notFoundChain   HandlerFunc                   // chain of the other not found IDs
+	outboundChain   HandlerFunc                   // chain of outboundMiddlewares, nil if there's none
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		handlerMapper:          make(map[interface{}]HandlerFunc),
+		middlewaresMapper:      make(map[interface{}][]MiddlewareFunc),
+		groupMiddlewaresMapper: make(map[interface{}][]MiddlewareFunc),
+		versionedRoutes:        make(map[interface{}][]*versionedRoute),
+		metaMapper:             make(map[interface{}]*routeMeta),
+		rawIDs:                 make(map[interface{}]interface{}),
+		timeouts:               make(map[interface{}]time.Duration),
+	}
+}
+
+// clone returns a copy of t, which can be modified without affecting t.
+// The middleware slices in maps are shared, since they're replaced instead of modified.
+func (t *routeTable) clone() *routeTable {
+	c := &routeTable{
+		handlerMapper:          make(map[interface{}]HandlerFunc, len(t.handlerMapper)),
+		middlewaresMapper:      make(map[interface{}][]MiddlewareFunc, len(t.middlewaresMapper)),
+		groupMiddlewaresMapper: make(map[interface{}][]MiddlewareFunc, len(t.groupMiddlewaresMapper)),
+		versionedRoutes:        make(map[interface{}][]*versionedRoute, len(t.versionedRoutes)),
+		metaMapper:             make(map[interface{}]*routeMeta, len(t.metaMapper)),
+		rawIDs:                 make(map[interface{}]interface{}, len(t.rawIDs)),
+		timeouts:               make(map[interface{}]time.Duration, len(t.timeouts)),
+		globalMiddlewares:      append([]MiddlewareFunc(nil), t.globalMiddlewares...),
+		rangeRoutes:            append([]*rangeRoute(nil), t.rangeRoutes...),
+		matchRoutes:            append([]*matchRoute(nil), t.matchRoutes...),
+		filters:                append([]FilterFunc(nil), t.filters...),
+		outboundMiddlewares:    append([]MiddlewareFunc(nil), t.outboundMiddlewares...),
+		notFoundHandler:        t.notFoundHandler,
+		handlerTimeout:         t.handlerTimeout,
+		timeoutHandler:         t.timeoutHandler,
+		errorHandler:           t.errorHandler,
+	}
+	for id, h := range t.handlerMapper {
+		c.handlerMapper[id] = h
+	}
+	for id, ms := range t.middlewaresMapper {
+		c.middlewaresMapper[id] = ms
+	}
+	for id, ms := range t.groupMiddlewaresMapper {
+		c.groupMiddlewaresMapper[id] = ms
+	}
+	for id, vrs := range t.versionedRoutes {
+		c.versionedRoutes[id] = vrs
+	}
+	for id, meta := range t.metaMapper {
+		c.metaMapper[id] = meta
+	}
+	for id, rawID := range t.rawIDs {
+		c.rawIDs[id] = rawID
+	}
+	for id, d := range t.timeouts {
+		c.timeouts[id] = d
+	}
+	return c
+}
+
+// setRawID stores rawID as the registered ID of the normalized id.
+// Panics if there's already one of another type, e.g. uint16(1) and int32(1).
+func (t *routeTable) setRawID(id, rawID interface{}) {
+	if registered, has := t.rawIDs[id]; has && reflect.TypeOf(registered) != reflect.TypeOf(rawID) {
+		panic(fmt.Sprintf("easytcp: message ID %v(%T) conflicts with the registered %v(%T)", rawID, rawID, registered, registered))
+	}
+	t.rawIDs[id] = rawID
+}
+
+// remove deletes the exact route of id.
+func (t *routeTable) remove(id interface{}) {
+	delete(t.handlerMapper, id)
+	delete(t.middlewaresMapper, id)
+	delete(t.groupMiddlewaresMapper, id)
+	delete(t.versionedRoutes, id)
+	delete(t.metaMapper, id)
+	delete(t.rawIDs, id)
+	delete(t.timeouts, id)
+}
+
+// chainOf returns the handler chain for msg of the protocol version.
+// Versioned routes win, followed by exact routes, range routes, match routes and the not-found handler.
+func (t *routeTable) chainOf(msg *Message, version int) HandlerFunc {
+	t.compileOnce.Do(t.compile)
+	id := normalizeID(msg.ID())
+	if len(t.versionedRoutes) != 0 {
+		if i := t.matchVersion(id, version); i >= 0 {
+			return t.versionedChains[id][i]
+		}
+	}
+	if n, ok := id.(int); ok && n >= 0 && n < maxFastRouteID {
+		if n < len(t.fastChains) && t.fastChains[n] != nil {
+			return t.fastChains[n]
+		}
+	} else if c, has := t.chains[id]; has {
+		return c
+	}
+	if i := t.matchRange(id); i >= 0 {
+		return t.rangeChains[i]
+	}
+	if i := t.matchPredicate(msg); i >= 0 {
+		return t.matchChains[i]
+	}
+	if c, has := t.notFoundChains[id]; has {
+		return c
+	}
+	return t.notFoundChain
+}
+
+// compile builds the handler chains of all routes.
+func (t *routeTable) compile() {
+	t.chains = make(map[interface{}]HandlerFunc, len(t.handlerMapper))
+	for id, h := range t.handlerMapper {
+		c := t.wrapHandlers(t.withTimeout(h, t.timeoutOf(id)), t.middlewaresOf(t.groupMiddlewaresMapper[id], t.middlewaresMapper[id]))
+		if n, ok := id.(int); ok && n >= 0 && n < maxFastRouteID {
+			if n >= len(t.fastChains) {
+				t.fastChains = append(t.fastChains, make([]HandlerFunc, n+1-len(t.fastChains))...)
+			}
+			t.fastChains[n] = c
+			continue
+		}
+		t.chains[id] = c
+	}
+
+	t.versionedChains = make(map[interface{}][]HandlerFunc, len(t.versionedRoutes))
+	for id, vrs := range t.versionedRoutes {
+		chains := make([]HandlerFunc, len(vrs))
+		for i, vr := range vrs {
+			chains[i] = t.wrapHandlers(t.withTimeout(vr.handler, t.timeoutOf(id)), t.middlewaresOf(vr.groupMiddlewares, vr.middlewares))
+		}
+		t.versionedChains[id] = chains
+	}
+
+	t.rangeChains = make([]HandlerFunc, len(t.rangeRoutes))
+	for i, rr := range t.rangeRoutes {
+		t.rangeChains[i] = t.wrapHandlers(t.withTimeout(rr.handler, t.handlerTimeout), t.middlewaresOf(rr.groupMiddlewares, rr.middlewares))
+	}
+	t.matchChains = make([]HandlerFunc, len(t.matchRoutes))
+	for i, mr := range t.matchRoutes {
+		t.matchChains[i] = t.wrapHandlers(t.withTimeout(mr.handler, t.handlerTimeout), t.middlewaresOf(mr.groupMiddlewares, mr.middlewares))
+	}
+
+	// middlewares registered without handler are applied to the not-found handler
+	t.notFoundChains = make(map[interface{}]HandlerFunc)
+	for id, ms := range t.middlewaresMapper {
+		if _, has := t.handlerMapper[id]; !has {
+			t.notFoundChains[id] = t.wrapHandlers(nil, t.middlewaresOf(nil, ms))
+		}
+	}
+	t.notFoundChain = t.wrapHandlers(nil, t.globalMiddlewares)
+
+	if len(t.outboundMiddlewares) != 0 {
+		t.outboundChain = t.wrapHandlers(nilHandler, t.outboundMiddlewares)
+	}
+}
+
+// handleError passes err to the error handler, or the defaultErrorHandler if not set.
+func (t *routeTable) handleError(ctx Context, err error) {
+	if t.errorHandler != nil {
+		t.errorHandler(ctx, err)
+		return
+	}
+	defaultErrorHandler(ctx, err)
+}
+
+// middlewaresOf returns a new slice of the global middlewares followed by groupMws and routeMws.
+func (t *routeTable) middlewaresOf(groupMws, routeMws []MiddlewareFunc) []MiddlewareFunc {
+	mws := make([]MiddlewareFunc, 0, len(t.globalMiddlewares)+len(groupMws)+len(routeMws))
+	mws = append(mws, t.globalMiddlewares...)
+	mws = append(mws, groupMws...)
+	return append(mws, routeMws...)
+}
+
+// wrapHandlers wraps handler and middlewares into a right order call stack.
+// Makes something like:
+//
+//	var wrapped HandlerFunc = m1(m2(m3(handle)))
+func (t *routeTable) wrapHandlers(handler HandlerFunc, middles []MiddlewareFunc) (wrapped HandlerFunc) {
+	if handler == nil {
+		handler = t.notFoundHandler
+	}
+	if handler == nil {
+		handler = nilHandler
+	}
+	wrapped = handler
+	for i := len(middles) - 1; i >= 0; i-- {
+		m := middles[i]
+		wrapped = m(wrapped)
+	}
+	return wrapped
+}
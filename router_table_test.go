@@ -0,0 +1,150 @@
+package easytcp
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func Test_routeTable_clone(t *testing.T) {
+	tb := newRouteTable()
+	tb.handlerMapper[1] = nilHandler
+	tb.globalMiddlewares = []MiddlewareFunc{func(next HandlerFunc) HandlerFunc { return next }}
+
+	c := tb.clone()
+	c.handlerMapper[2] = nilHandler
+	c.globalMiddlewares = append(c.globalMiddlewares, nil)
+	c.remove(1)
+
+	assert.Len(t, tb.handlerMapper, 1)
+	assert.Len(t, tb.globalMiddlewares, 1)
+	assert.Len(t, c.handlerMapper, 1)
+	assert.Contains(t, c.handlerMapper, 2)
+}
+
+func TestRouter_replace(t *testing.T) {
+	rt := newRouter()
+	var result []string
+	m := func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			result = append(result, "middleware")
+			next(ctx)
+		}
+	}
+	rt.registerInGroup(1, []MiddlewareFunc{m}, nilHandler, m)
+	rt.replace(1, func(ctx Context) { result = append(result, "replaced") })
+	rt.handleRequest(&routeContext{reqMsg: NewMessage(1, nil)})
+	assert.Equal(t, []string{"replaced"}, result)
+
+	rt.replace(1, nil)
+	assert.Empty(t, rt.routes().handlerMapper)
+}
+
+func TestRouter_remove(t *testing.T) {
+	rt := newRouter()
+	rt.register(1, nilHandler, func(next HandlerFunc) HandlerFunc { return next })
+	rt.setRouteMeta(1, &routeMeta{handlerName: "test"})
+	rt.remove(1)
+	assert.Empty(t, rt.routes().handlerMapper)
+	assert.Empty(t, rt.routes().middlewaresMapper)
+	assert.Empty(t, rt.routes().metaMapper)
+
+	var notFound bool
+	rt.setNotFoundHandler(func(ctx Context) { notFound = true })
+	rt.handleRequest(&routeContext{reqMsg: NewMessage(1, nil)})
+	assert.True(t, notFound)
+}
+
+func TestRouter_concurrentUpdate(t *testing.T) {
+	rt := newRouter()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(id int) {
+			defer wg.Done()
+			rt.register(id, nilHandler)
+			rt.registerMiddleware(func(next HandlerFunc) HandlerFunc { return next })
+			rt.replace(id, nilHandler)
+			rt.remove(id)
+		}(i)
+		go func(id int) {
+			defer wg.Done()
+			rt.handleRequest(&routeContext{reqMsg: NewMessage(id, nil)})
+		}(i)
+	}
+	wg.Wait()
+	assert.Empty(t, rt.routes().handlerMapper)
+	assert.Len(t, rt.routes().globalMiddlewares, 10)
+}
+
+func TestRouter_concurrentSetErrorHandler(t *testing.T) {
+	rt := newRouter()
+	rt.register(1, handlerFuncOf(func(ctx Context) error { return fmt.Errorf("failed") }))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rt.setErrorHandler(func(ctx Context, err error) {})
+		}()
+		go func() {
+			defer wg.Done()
+			rt.handleRequest(&routeContext{reqMsg: NewMessage(1, nil)})
+		}()
+	}
+	wg.Wait()
+	assert.NotNil(t, rt.routes().errorHandler)
+}
+
+func Test_routeTable_chainOf(t *testing.T) {
+	rt := newRouter()
+	var result []string
+	newMiddleware := func(name string) MiddlewareFunc {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx Context) {
+				result = append(result, name)
+				next(ctx)
+			}
+		}
+	}
+	newHandler := func(name string) HandlerFunc {
+		return func(ctx Context) { result = append(result, name) }
+	}
+
+	// global middlewares with spare capacity, which must not be shared by routes
+	globals := make([]MiddlewareFunc, 0, 10)
+	globals = append(globals, newMiddleware("global"))
+	rt.registerMiddleware(globals...)
+	rt.register(1, newHandler("fast"), newMiddleware("m1"))
+	rt.register(2, newHandler("fast"), newMiddleware("m2"))
+	rt.register(maxFastRouteID, newHandler("slow"), newMiddleware("m3"))
+	rt.register("str", newHandler("str"))
+	rt.register(5, nil, newMiddleware("m5"))
+	rt.setNotFoundHandler(newHandler("not found"))
+
+	cases := []struct {
+		id     interface{}
+		expect []string
+	}{
+		{1, []string{"global", "m1", "fast"}},
+		{2, []string{"global", "m2", "fast"}},
+		{maxFastRouteID, []string{"global", "m3", "slow"}},
+		{"str", []string{"global", "str"}},
+		{3, []string{"global", "not found"}},
+		{-1, []string{"global", "not found"}},
+		{5, []string{"global", "m5", "not found"}},
+	}
+	for _, c := range cases {
+		result = nil
+		rt.handleRequest(&routeContext{reqMsg: NewMessage(c.id, nil)})
+		assert.Equal(t, c.expect, result, "id %v", c.id)
+	}
+	assert.Len(t, rt.routes().fastChains, 3)
+
+	// a new table is compiled after update
+	rt.remove(1)
+	result = nil
+	rt.handleRequest(&routeContext{reqMsg: NewMessage(1, nil)})
+	assert.Equal(t, []string{"global", "not found"}, result)
+}
@@ -1,7 +1,9 @@
 package easytcp
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"reflect"
 	"runtime"
 	"testing"
@@ -13,9 +15,9 @@ func TestRouter_register(t *testing.T) {
 	var id = 1
 
 	rt.register(id, nil)
-	_, ok := rt.handlerMapper[id]
+	_, ok := rt.routes().handlerMapper[id]
 	assert.False(t, ok)
-	_, ok = rt.middlewaresMapper[id]
+	_, ok = rt.routes().middlewaresMapper[id]
 	assert.False(t, ok)
 
 	h := nilHandler
@@ -30,12 +32,12 @@ func TestRouter_register(t *testing.T) {
 		}
 	}
 	rt.register(id, h, m1, nil, m2)
-	v, ok := rt.handlerMapper[id]
+	v, ok := rt.routes().handlerMapper[id]
 	assert.True(t, ok)
 	expect := runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
 	actual := runtime.FuncForPC(reflect.ValueOf(v).Pointer()).Name()
 	assert.Equal(t, expect, actual)
-	mhs, ok := rt.middlewaresMapper[id]
+	mhs, ok := rt.routes().middlewaresMapper[id]
 	assert.True(t, ok)
 	expects := []MiddlewareFunc{m1, m2}
 	for i, mh := range mhs {
@@ -49,10 +51,10 @@ func TestRouter_registerMiddleware(t *testing.T) {
 	rt := newRouter()
 
 	rt.registerMiddleware()
-	assert.Len(t, rt.globalMiddlewares, 0)
+	assert.Len(t, rt.routes().globalMiddlewares, 0)
 
 	rt.registerMiddleware(nil, nil)
-	assert.Len(t, rt.globalMiddlewares, 0)
+	assert.Len(t, rt.routes().globalMiddlewares, 0)
 
 	m1 := func(next HandlerFunc) HandlerFunc {
 		return func(ctx Context) {
@@ -70,13 +72,13 @@ func TestRouter_registerMiddleware(t *testing.T) {
 		}
 	}
 	rt.registerMiddleware(m1, m2)
-	assert.Len(t, rt.globalMiddlewares, 2)
+	assert.Len(t, rt.routes().globalMiddlewares, 2)
 
 	rt.registerMiddleware(m3)
-	assert.Len(t, rt.globalMiddlewares, 3)
+	assert.Len(t, rt.routes().globalMiddlewares, 3)
 
 	expects := []MiddlewareFunc{m1, m2, m3}
-	for i, m := range rt.globalMiddlewares {
+	for i, m := range rt.routes().globalMiddlewares {
 		expect := runtime.FuncForPC(reflect.ValueOf(expects[i]).Pointer()).Name()
 		actual := runtime.FuncForPC(reflect.ValueOf(m).Pointer()).Name()
 		assert.Equal(t, expect, actual)
@@ -110,10 +112,47 @@ func TestRouter_handleReq(t *testing.T) {
 	})
 }
 
+func TestRouter_handleReq_handlerError(t *testing.T) {
+	rt := newRouter()
+	var seenByMiddleware, handled error
+	rt.registerMiddleware(func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			next(ctx)
+			seenByMiddleware = ctx.HandlerError()
+		}
+	})
+	rt.register(1, handlerFuncOf(func(ctx Context) error {
+		return fmt.Errorf("bad request")
+	}))
+	rt.register(2, handlerFuncOf(func(ctx Context) error {
+		ctx.SetResponseMessage(NewMessage(2, []byte("ok")))
+		return nil
+	}))
+	rt.setErrorHandler(func(ctx Context, err error) {
+		handled = err
+		ctx.SetResponseMessage(NewMessage(500, []byte(err.Error())))
+	})
+
+	ctx := &routeContext{reqMsg: NewMessage(1, []byte("test"))}
+	rt.handleRequest(ctx)
+	assert.EqualError(t, seenByMiddleware, "bad request")
+	assert.EqualError(t, handled, "bad request")
+	assert.EqualValues(t, 500, ctx.respMsg.ID())
+
+	seenByMiddleware, handled = nil, nil
+	ctx = &routeContext{reqMsg: NewMessage(2, []byte("test"))}
+	rt.handleRequest(ctx)
+	assert.NoError(t, seenByMiddleware)
+	assert.NoError(t, handled)
+	assert.EqualValues(t, 2, ctx.respMsg.ID())
+
+	assert.Nil(t, handlerFuncOf(nil))
+}
+
 func TestRouter_wrapHandlers(t *testing.T) {
 	rt := newRouter()
 	t.Run("it works when there's no handler nor middleware", func(t *testing.T) {
-		wrap := rt.wrapHandlers(nil, nil)
+		wrap := rt.routes().wrapHandlers(nil, nil)
 		ctx := &routeContext{}
 		wrap(ctx)
 		assert.Nil(t, ctx.respMsg)
@@ -147,7 +186,7 @@ func TestRouter_wrapHandlers(t *testing.T) {
 			ctx.SetResponseMessage(NewMessage(2, []byte("done")))
 		}
 
-		wrap := rt.wrapHandlers(handler, middles)
+		wrap := rt.routes().wrapHandlers(handler, middles)
 		ctx := &routeContext{}
 		wrap(ctx)
 		assert.EqualValues(t, ctx.respMsg.Data(), "done")
@@ -158,7 +197,7 @@ func TestRouter_wrapHandlers(t *testing.T) {
 func TestRouter_printHandlers(t *testing.T) {
 	t.Run("when there's no route registered", func(t *testing.T) {
 		rt := newRouter()
-		rt.printHandlers("localhost")
+		rt.printHandlers(os.Stdout, "localhost")
 	})
 	t.Run("when there are routes registered", func(t *testing.T) {
 		rt := newRouter()
@@ -191,13 +230,34 @@ func TestRouter_printHandlers(t *testing.T) {
 		rt.register(12345678, nilHandler)
 		rt.register(12345, nilHandler)
 		rt.register(123456, nilHandler)
-		rt.printHandlers("localhost")
+		rt.printHandlers(os.Stdout, "localhost")
 	})
 }
 
 func TestRouter_setNotFoundHandler(t *testing.T) {
 	rt := newRouter()
-	assert.Nil(t, rt.notFoundHandler)
+	assert.Nil(t, rt.routes().notFoundHandler)
 	rt.setNotFoundHandler(func(ctx Context) {})
-	assert.NotNil(t, rt.notFoundHandler)
+	assert.NotNil(t, rt.routes().notFoundHandler)
+}
+
+func TestRouter_handleOutbound(t *testing.T) {
+	rt := newRouter()
+	rt.setNotFoundHandler(func(ctx Context) { t.Fatal("not found handler shouldn't be called") })
+	ctx := newContext().SetResponseMessage(NewMessage(1, []byte("test")))
+	rt.handleOutbound(ctx) // no outbound middlewares
+
+	var result []string
+	newMiddleware := func(name string) MiddlewareFunc {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(ctx Context) {
+				result = append(result, name)
+				next(ctx)
+			}
+		}
+	}
+	rt.registerOutboundMiddleware(newMiddleware("m1"), nil, newMiddleware("m2"))
+	rt.registerOutboundMiddleware()
+	rt.handleOutbound(ctx)
+	assert.Equal(t, []string{"m1", "m2"}, result)
 }
@@ -0,0 +1,39 @@
+package easytcp
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+)
+
+// AddTypedRoute registers a typed handler and middlewares for reqID to the server.
+// The request data is decoded into a *Req with the session's codec before calling handler,
+// and the *Resp returned is encoded and set as the response with respID.
+// No response is set if handler returns a nil *Resp.
+// Errors of decoding, handling and encoding are set to the Context with SetHandlerError.
+func AddTypedRoute[Req, Resp any](s *Server, reqID, respID interface{}, handler func(ctx Context, req *Req) (*Resp, error), middlewares ...MiddlewareFunc) {
+	h := func(ctx Context) {
+		var req Req
+		if err := ctx.Bind(&req); err != nil {
+			ctx.SetHandlerError(fmt.Errorf("bind request err: %w", err))
+			return
+		}
+		resp, err := handler(ctx, &req)
+		if err != nil {
+			ctx.SetHandlerError(err)
+			return
+		}
+		if resp == nil {
+			return
+		}
+		if err := ctx.SetResponse(respID, resp); err != nil {
+			ctx.SetHandlerError(fmt.Errorf("set response err: %w", err))
+		}
+	}
+	s.router.register(reqID, h, middlewares...)
+	s.router.setRouteMeta(reqID, &routeMeta{
+		handlerName: runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name(),
+		reqType:     reflect.TypeOf((*Req)(nil)),
+		respType:    reflect.TypeOf((*Resp)(nil)),
+	})
+}
@@ -0,0 +1,100 @@
+package easytcp
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+type typedTestReq struct {
+	Name string `json:"name"`
+}
+
+type typedTestResp struct {
+	Greeting string `json:"greeting"`
+}
+
+func typedTestHandler(_ Context, req *typedTestReq) (*typedTestResp, error) {
+	switch req.Name {
+	case "":
+		return nil, fmt.Errorf("name is required")
+	case "nobody":
+		return nil, nil
+	case "invalid":
+		return &typedTestResp{Greeting: string([]byte{0xff})}, nil
+	}
+	return &typedTestResp{Greeting: "hello " + req.Name}, nil
+}
+
+func TestAddTypedRoute(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	AddTypedRoute(s, 1, 2, typedTestHandler)
+
+	var handledErr error
+	s.ErrorHandler(func(ctx Context, err error) {
+		handledErr = err
+		ctx.SetResponseMessage(NewMessage(500, []byte(err.Error())))
+	})
+
+	newCtx := func(data string) *routeContext {
+		sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}})
+		return newTestContext(sess, NewMessage(1, []byte(data)))
+	}
+
+	t.Run("when succeed", func(t *testing.T) {
+		handledErr = nil
+		ctx := newCtx(`{"name":"easytcp"}`)
+		s.router.handleRequest(ctx)
+		assert.NoError(t, handledErr)
+		assert.EqualValues(t, 2, ctx.respMsg.ID())
+		assert.JSONEq(t, `{"greeting":"hello easytcp"}`, string(ctx.respMsg.Data()))
+	})
+	t.Run("when handler returns nil response", func(t *testing.T) {
+		handledErr = nil
+		ctx := newCtx(`{"name":"nobody"}`)
+		s.router.handleRequest(ctx)
+		assert.NoError(t, handledErr)
+		assert.Nil(t, ctx.respMsg)
+	})
+	t.Run("when bind failed", func(t *testing.T) {
+		ctx := newCtx(`not json`)
+		s.router.handleRequest(ctx)
+		assert.Error(t, handledErr)
+		assert.EqualValues(t, 500, ctx.respMsg.ID())
+	})
+	t.Run("when handler returns error", func(t *testing.T) {
+		ctx := newCtx(`{}`)
+		s.router.handleRequest(ctx)
+		assert.EqualError(t, handledErr, "name is required")
+		assert.EqualValues(t, 500, ctx.respMsg.ID())
+	})
+	t.Run("when encode failed", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}})
+		ctx := newTestContext(sess, NewMessage(1, []byte(`{"name":"easytcp"}`)))
+		sess.codec = nil // bind is fine, but can't encode
+		ctx.reqMsg = NewMessage(1, []byte(`{"name":"easytcp"}`))
+		s.router.handleRequest(ctx)
+		assert.Error(t, handledErr)
+	})
+	t.Run("when there's no error handler", func(t *testing.T) {
+		s.ErrorHandler(nil)
+		ctx := newCtx(`{}`)
+		s.router.handleRequest(ctx)
+		assert.Nil(t, ctx.respMsg)
+	})
+}
+
+func TestAddTypedRoute_printHandlers(t *testing.T) {
+	rt := newRouter()
+	AddTypedRoute(&Server{router: rt}, 1, 2, typedTestHandler)
+	meta := rt.routes().metaMapper[1]
+	assert.Contains(t, meta.handlerName, "typedTestHandler")
+	assert.Equal(t, "*easytcp.typedTestReq", meta.reqType.String())
+	assert.Equal(t, "*easytcp.typedTestResp", meta.respType.String())
+	rt.printHandlers(os.Stdout, "localhost")
+
+	// registered again with a plain handler
+	rt.register(1, nilHandler)
+	assert.Empty(t, rt.routes().metaMapper)
+}
@@ -2,8 +2,12 @@ package easytcp
 
 import (
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -25,6 +29,15 @@ type Server struct {
 	// OnSessionClose is an event hook, will be invoked when session's closed.
 	OnSessionClose func(sess Session)
 
+	// OnSessionResume is an event hook, will be invoked when session's resumed by a new connection.
+	// The new connection has got a session of its own with OnSessionCreate before it presents the token,
+	// and that session is closed with OnSessionClose right before the resumed one is served.
+	OnSessionResume func(sess Session)
+
+	// OnDeliveryFailed is an event hook, will be invoked when a message sent by Session.SendReliable
+	// is not acked after max retries (with ErrDeliveryFailed), or the session's closed (with ErrSessionClosed).
+	OnDeliveryFailed func(sess Session, msg *Message, err error)
+
 	socketReadBufferSize  int
 	socketWriteBufferSize int
 	socketSendDelay       bool
@@ -33,9 +46,23 @@ type Server struct {
 	respQueueSize         int
 	router                *Router
 	printRoutes           bool
+	routesWriter          io.Writer
 	acceptingC            chan struct{}
 	stoppedC              chan struct{}
 	asyncRouter           bool
+	rateLimit             *RateLimit
+	msgRateLimits         map[interface{}]*RateLimit
+	pool                  *workerPool
+	orderedRouter         bool
+	orderedQueueSize      int
+	orderKey              func(msg *Message) interface{}
+	resumeGracePeriod     time.Duration
+	resumeMessageID       interface{}
+	resumablesMu          sync.Mutex
+	resumables            map[string]*session
+	ackMessageID          interface{}
+	retryInterval         time.Duration
+	maxRetries            int
 }
 
 // ServerOption is the option for Server.
@@ -49,10 +76,70 @@ type ServerOption struct {
 	Codec                 Codec         // encodes and decodes the message data, can be nil.
 	RespQueueSize         int           // sets the response channel size of session, DefaultRespQueueSize will be used if < 0.
 	DoNotPrintRoutes      bool          // whether to print registered route handlers to the console.
+	RoutesWriter          io.Writer     // where to print registered route handlers, default is os.Stdout.
 
 	// AsyncRouter represents whether to execute a route HandlerFunc of each session in a goroutine.
 	// true means execute in a goroutine.
 	AsyncRouter bool
+
+	// OrderedRouter represents whether to execute route HandlerFunc of each session in order,
+	// while the ones of different sessions are executed concurrently.
+	// Responses of a session are sent in the order of requests.
+	// It takes precedence over AsyncRouter.
+	OrderedRouter bool
+
+	// OrderKey extracts the ordering key from message when OrderedRouter is true.
+	// Messages with the same key in a session are handled in order, and the ones with different keys concurrently.
+	// nil means all messages in a session share the same key.
+	OrderKey func(msg *Message) interface{}
+
+	// OrderedQueueSize sets the max number of messages pending or being handled in a session when OrderedRouter is true.
+	// Reading messages is blocked when it's reached. DefaultOrderedQueueSize will be used if <= 0.
+	OrderedQueueSize int
+
+	// WorkerPoolSize sets the number of workers to execute route HandlerFunc when AsyncRouter or OrderedRouter is true.
+	// 0 means executing HandlerFunc in new goroutines.
+	WorkerPoolSize int
+
+	// WorkerQueueSize sets the task queue size of the worker pool.
+	// Reading messages is blocked when the queue is full.
+	WorkerQueueSize int
+
+	// ResumeGracePeriod sets how long a session is kept after its connection's lost,
+	// waiting to be resumed by a new connection with its resume token.
+	// During the period, the session keeps its ID and the outbound messages not written.
+	// 0 means session resumption is disabled.
+	ResumeGracePeriod time.Duration
+
+	// ResumeMessageID is the ID of the message to resume a session, whose data is the resume token.
+	// A session is created for each connection as usual, and if the first message it reads is a valid resume message,
+	// the connection takes over the session of the token, and the new session is closed.
+	// Otherwise, the message is routed as usual.
+	ResumeMessageID interface{}
+
+	// AckMessageID is the ID of the message to ack the ones sent by Session.SendReliable.
+	// An inbound message with this ID and a delivery ID stored with MessageDeliveryKey is treated as an ack, and won't be routed.
+	AckMessageID interface{}
+
+	// RetryInterval sets the interval to retransmit the messages not acked.
+	// 0 means retransmitting only when the session's resumed.
+	RetryInterval time.Duration
+
+	// MaxRetries sets the max retransmissions of a message not acked, 0 means no limit.
+	MaxRetries int
+
+	// RateLimit limits the inbound messages of each session, nil means no limit.
+	RateLimit *RateLimit
+
+	// MessageRateLimits overrides RateLimit for specific message IDs.
+	// Each message ID has its own token bucket in a session.
+	MessageRateLimits map[interface{}]*RateLimit
+
+	// HandlerTimeout is the default timeout of route handlers, 0 means no timeout.
+	// The deadline is set on the Context, and is removed once the handler returns in time,
+	// along with the ones of the copies and streams made in the handler.
+	// The response of a timed-out handler is dropped, see Server.TimeoutHandler.
+	HandlerTimeout time.Duration
 }
 
 // ErrServerStopped is returned when server stopped.
@@ -60,6 +147,8 @@ var ErrServerStopped = fmt.Errorf("server stopped")
 
 const DefaultRespQueueSize = 1024
 
+const DefaultOrderedQueueSize = 1024
+
 // NewServer creates a Server according to opt.
 func NewServer(opt *ServerOption) *Server {
 	if opt.Packer == nil {
@@ -68,6 +157,20 @@ func NewServer(opt *ServerOption) *Server {
 	if opt.RespQueueSize < 0 {
 		opt.RespQueueSize = DefaultRespQueueSize
 	}
+	if opt.OrderedQueueSize <= 0 {
+		opt.OrderedQueueSize = DefaultOrderedQueueSize
+	}
+	if opt.RoutesWriter == nil {
+		opt.RoutesWriter = os.Stdout
+	}
+	router := newRouter()
+	if opt.HandlerTimeout > 0 {
+		router.setDefaultTimeout(opt.HandlerTimeout)
+	}
+	var pool *workerPool
+	if (opt.AsyncRouter || opt.OrderedRouter) && opt.WorkerPoolSize > 0 {
+		pool = newWorkerPool(opt.WorkerPoolSize, opt.WorkerQueueSize)
+	}
 	return &Server{
 		socketReadBufferSize:  opt.SocketReadBufferSize,
 		socketWriteBufferSize: opt.SocketWriteBufferSize,
@@ -78,18 +181,35 @@ func NewServer(opt *ServerOption) *Server {
 		Packer:                opt.Packer,
 		Codec:                 opt.Codec,
 		printRoutes:           !opt.DoNotPrintRoutes,
-		router:                newRouter(),
+		routesWriter:          opt.RoutesWriter,
+		router:                router,
 		acceptingC:            make(chan struct{}),
 		stoppedC:              make(chan struct{}),
 		asyncRouter:           opt.AsyncRouter,
+		rateLimit:             opt.RateLimit,
+		msgRateLimits:         normalizeRateLimits(opt.MessageRateLimits),
+		pool:                  pool,
+		orderedRouter:         opt.OrderedRouter,
+		orderKey:              opt.OrderKey,
+		orderedQueueSize:      opt.OrderedQueueSize,
+		resumeGracePeriod:     opt.ResumeGracePeriod,
+		resumeMessageID:       normalizeID(opt.ResumeMessageID),
+		resumables:            make(map[string]*session),
+		ackMessageID:          normalizeID(opt.AckMessageID),
+		retryInterval:         opt.RetryInterval,
+		maxRetries:            opt.MaxRetries,
 	}
 }
 
 // Serve starts to serve the lis.
 func (s *Server) Serve(lis net.Listener) error {
 	s.Listener = lis
+	s.router.compile()
 	if s.printRoutes {
-		s.router.printHandlers(fmt.Sprintf("tcp://%s", s.Listener.Addr()))
+		s.router.printHandlers(s.routesWriter, fmt.Sprintf("tcp://%s", s.Listener.Addr()))
+	}
+	if s.pool != nil {
+		s.pool.start()
 	}
 	return s.acceptLoop()
 }
@@ -158,29 +278,103 @@ func (s *Server) acceptLoop() error {
 
 // handleConn creates a new session with `conn`,
 // handles the message through the session in different goroutines,
-// and waits until the session's closed, then close the `conn`.
+// and waits until the session's closed or detached, then close the `conn`.
+// If `conn` resumes another session, the resumed one is served instead.
 func (s *Server) handleConn(conn net.Conn) {
 	defer conn.Close() // nolint
 
-	sess := newSession(conn, &sessionOption{
-		Packer:        s.Packer,
-		Codec:         s.Codec,
-		respQueueSize: s.respQueueSize,
-		asyncRouter:   s.asyncRouter,
-	})
+	sess := s.newSession(conn)
+	if sess.resumeToken != "" {
+		s.registerResumable(sess)
+	}
 	if s.OnSessionCreate != nil {
 		s.OnSessionCreate(sess)
 	}
 	close(sess.afterCreateHookC)
 
-	go sess.readInbound(s.router, s.readTimeout) // start reading message packet from connection.
-	go sess.writeOutbound(s.writeTimeout)        // start writing message packet to connection.
+	for resumed := false; sess != nil; resumed = true {
+		sess = s.serveSession(sess, resumed)
+	}
+}
+
+// newSession creates a session for conn with the server's options.
+func (s *Server) newSession(conn net.Conn) *session {
+	opt := &sessionOption{
+		Packer:           s.Packer,
+		Codec:            s.Codec,
+		respQueueSize:    s.respQueueSize,
+		asyncRouter:      s.asyncRouter,
+		rateLimit:        s.rateLimit,
+		msgRateLimits:    s.msgRateLimits,
+		pool:             s.pool,
+		orderedRouter:    s.orderedRouter,
+		orderKey:         s.orderKey,
+		orderedQueueSize: s.orderedQueueSize,
+		ackMessageID:     s.ackMessageID,
+		retryInterval:    s.retryInterval,
+		maxRetries:       s.maxRetries,
+		onDeliveryFailed: s.OnDeliveryFailed,
+		outbound:         s.router.handleOutbound,
+	}
+	if s.resumable() {
+		opt.resumable = true
+		opt.resumeMessageID = s.resumeMessageID
+		opt.resume = s.resumeSession
+	}
+	return newSession(conn, opt)
+}
+
+// serveSession runs the read and write loops of sess, and waits until the session's closed or detached.
+// Returns the session resumed by the connection of sess, which should be served next, or nil.
+func (s *Server) serveSession(sess *session, resumed bool) *session {
+	if resumed && s.OnSessionResume != nil {
+		s.OnSessionResume(sess)
+	}
+
+	conn, _, lostC := sess.connection()
+	readDone, writeDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		sess.readInbound(s.router, s.readTimeout) // start reading message packet from connection.
+		close(readDone)
+	}()
+	go func() {
+		sess.writeOutbound(s.writeTimeout) // start writing message packet to connection.
+		close(writeDone)
+	}()
+	if resumed {
+		go sess.retransmit(time.Now()) // messages sent before might be lost with the old connection
+	}
 
 	select {
 	case <-sess.closedC: // wait for session finished.
 	case <-s.stoppedC: // or the server is stopped.
+	case <-lostC: // or the connection of a resumable session is lost.
+		_ = conn.Close()
+		<-readDone  // the handler of the last message might be running in the read loop
+		<-writeDone // messages not written are kept in the queue
+		if !sess.isClosed() {
+			go s.awaitResume(sess, sess.detach())
+			return nil
+		}
+	case old := <-sess.takeoverC: // or the connection resumes another session.
+		sess.Close()
+		<-readDone
+		<-writeDone // the connection's written by old only
+		s.closeSession(sess)
+		return old
 	}
 
+	sess.Close()
+	s.closeSession(sess)
+	return nil
+}
+
+// closeSession reports the messages not acked, invokes the on-close hook and forgets the session.
+func (s *Server) closeSession(sess *session) {
+	if sess.resumeToken != "" {
+		s.unregisterResumable(sess)
+	}
+	sess.failDeliveries(ErrSessionClosed)
 	if s.OnSessionClose != nil {
 		s.OnSessionClose(sess)
 	}
@@ -190,24 +384,145 @@ func (s *Server) handleConn(conn net.Conn) {
 // Stop stops server. Closing Listener and all connections.
 func (s *Server) Stop() error {
 	close(s.stoppedC)
+	if s.pool != nil {
+		s.pool.stop()
+	}
 	return s.Listener.Close()
 }
 
 // AddRoute registers message handler and middlewares to the router.
+// Integer msgID of any type is normalized, panics if it conflicts with a registered one of another type.
+// It's safe to be called while serving.
 func (s *Server) AddRoute(msgID interface{}, handler HandlerFunc, middlewares ...MiddlewareFunc) {
 	s.router.register(msgID, handler, middlewares...)
 }
 
+// RemoveRoute removes the routes of msgID, which are registered with AddRoute, AddRouteE, AddTypedRoute or AddVersionedRoute.
+// It's safe to be called while serving, the messages being handled are not affected.
+func (s *Server) RemoveRoute(msgID interface{}) {
+	s.router.remove(msgID)
+}
+
+// ReplaceRoute replaces the route of msgID with handler and middlewares.
+// Unlike AddRoute, the middlewares and versioned routes registered before are dropped.
+// It's safe to be called while serving, the messages being handled are not affected.
+func (s *Server) ReplaceRoute(msgID interface{}, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	s.router.replace(msgID, handler, middlewares...)
+}
+
+// AddRouteE registers message handler which returns an error, and middlewares to the router.
+// The error returned is visible to middlewares with Context.HandlerError,
+// and is passed to the error handler at last.
+func (s *Server) AddRouteE(msgID interface{}, handler HandlerFuncE, middlewares ...MiddlewareFunc) {
+	s.router.register(msgID, handlerFuncOf(handler), middlewares...)
+}
+
+// AddHandshakeRoute registers a route for msgID to negotiate the protocol version with handshake,
+// the version returned is stored in the session, and the error returned is passed to the error handler.
+func (s *Server) AddHandshakeRoute(msgID interface{}, handshake HandshakeFunc, middlewares ...MiddlewareFunc) {
+	s.router.register(msgID, handshakeHandler(handshake), middlewares...)
+}
+
+// AddVersionedRoute registers message handler and middlewares for msgID,
+// which only handle the messages of the sessions whose protocol version is in [minVersion, maxVersion].
+// Versioned routes are looked up before the route registered by AddRoute for the same msgID,
+// which handles the other versions.
+// Panics if the version range is invalid or overlaps with another one of msgID.
+func (s *Server) AddVersionedRoute(msgID interface{}, minVersion, maxVersion int, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	s.router.registerVersioned(msgID, minVersion, maxVersion, nil, handler, middlewares...)
+}
+
+// AddRangeRoute registers message handler and middlewares for the message IDs in [fromID, toID].
+// Range routes are looked up in the order of registration, when there's no route for the exact ID.
+// Panics if fromID is greater than toID.
+func (s *Server) AddRangeRoute(fromID, toID int64, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	s.router.registerRange(fromID, toID, nil, handler, middlewares...)
+}
+
+// AddMatchRoute registers message handler and middlewares for the messages which match reports true.
+// Match routes are looked up in the order of registration, when there's no exact nor range route for the message.
+func (s *Server) AddMatchRoute(match MatchFunc, handler HandlerFunc, middlewares ...MiddlewareFunc) {
+	s.router.registerMatch(match, nil, handler, middlewares...)
+}
+
 // Use registers global middlewares to the router.
 func (s *Server) Use(middlewares ...MiddlewareFunc) {
 	s.router.registerMiddleware(middlewares...)
 }
 
+// UseFilter registers inbound filters, which see each message right after it's unpacked,
+// before the rate limit and routing. Filters can drop, rewrite or reply to the message.
+// It's safe to be called while serving.
+func (s *Server) UseFilter(filters ...FilterFunc) {
+	s.router.registerFilter(filters...)
+}
+
+// UseOutbound registers outbound middlewares, which are called on every Context sent to sessions,
+// right before the response message is packed, including the ones sent outside of handlers.
+// Middlewares can modify the response message, or set it to nil to drop it.
+func (s *Server) UseOutbound(middlewares ...MiddlewareFunc) {
+	s.router.registerOutboundMiddleware(middlewares...)
+}
+
+// Group creates a RouteGroup with middlewares.
+// Routes in the group will be applied with the middlewares, after the global ones.
+func (s *Server) Group(middlewares ...MiddlewareFunc) *RouteGroup {
+	g := &RouteGroup{router: s.router}
+	g.Use(middlewares...)
+	return g
+}
+
 // NotFoundHandler sets the not-found handler for router.
 func (s *Server) NotFoundHandler(handler HandlerFunc) {
 	s.router.setNotFoundHandler(handler)
 }
 
+// SetRouteTimeout sets the handler timeout of the route of msgID, overriding ServerOption.HandlerTimeout.
+// A negative timeout means no timeout for the route.
+func (s *Server) SetRouteTimeout(msgID interface{}, timeout time.Duration) {
+	s.router.setTimeout(msgID, timeout)
+}
+
+// TimeoutHandler sets the handler for router, which sets the response when a route handler times out.
+// It's called at the deadline with a detached copy of the Context, which has the values stored by the handler so far,
+// and the response it sets is sent at once,
+// while the response and error set by the timed-out handler are dropped.
+func (s *Server) TimeoutHandler(handler HandlerFunc) {
+	s.router.setTimeoutHandler(handler)
+}
+
+// ErrorHandler sets the error handler for router.
+// It handles the errors set by Context.SetHandlerError after the middlewares,
+// and can set an error response or close the session.
+func (s *Server) ErrorHandler(handler ErrorHandlerFunc) {
+	s.router.setErrorHandler(handler)
+}
+
+// SetRouteName sets the name of the route of msgID, which is shown in the route table and Routes.
+// It should be called after the route is registered, since registering drops the name.
+func (s *Server) SetRouteName(msgID interface{}, name string) {
+	s.router.setRouteName(msgID, name)
+}
+
+// Routes returns the information of registered routes, in the order of looking up.
+func (s *Server) Routes() []RouteInfo {
+	return s.router.routes().routeInfos()
+}
+
+// RoutesJSON returns the indented JSON of Routes, which is handy to diff between releases.
+func (s *Server) RoutesJSON() ([]byte, error) {
+	return json.MarshalIndent(s.Routes(), "", "  ")
+}
+
+// WorkerPoolStats returns the statistics of the worker pool.
+// Returns zero value if the worker pool is not enabled.
+func (s *Server) WorkerPoolStats() WorkerPoolStats {
+	if s.pool == nil {
+		return WorkerPoolStats{}
+	}
+	return s.pool.stats()
+}
+
 func (s *Server) isStopped() bool {
 	select {
 	case <-s.stoppedC:
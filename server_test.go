@@ -289,3 +289,92 @@ func TestServer_SessionHooks(t *testing.T) {
 	assert.NoError(t, cli.Close())
 	<-theSess.AfterCloseHook()
 }
+
+func TestServer_WorkerPoolStats(t *testing.T) {
+	server := NewServer(&ServerOption{})
+	assert.Equal(t, WorkerPoolStats{}, server.WorkerPoolStats())
+
+	server = NewServer(&ServerOption{WorkerPoolSize: 4, WorkerQueueSize: 16})
+	assert.Nil(t, server.pool) // AsyncRouter is false
+
+	server = NewServer(&ServerOption{AsyncRouter: true, WorkerPoolSize: 4, WorkerQueueSize: 16})
+	assert.Equal(t, WorkerPoolStats{Workers: 4, QueueSize: 16}, server.WorkerPoolStats())
+
+	go func() {
+		assert.ErrorIs(t, server.Run("localhost:0"), ErrServerStopped)
+	}()
+	<-server.acceptingC
+	assert.NoError(t, server.Stop())
+	assert.False(t, server.pool.submit(func() {}, nil))
+}
+
+func TestServer_UseOutbound(t *testing.T) {
+	server := NewServer(&ServerOption{DoNotPrintRoutes: true})
+	server.UseOutbound(func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			resp := ctx.Response()
+			ctx.SetResponseMessage(NewMessage(resp.ID(), append([]byte("signed:"), resp.Data()...)))
+			next(ctx)
+		}
+	})
+	server.AddRoute(1, func(ctx Context) {
+		ctx.SetResponseMessage(NewMessage(2, []byte("pong")))
+	})
+	sessC := make(chan Session, 1)
+	server.OnSessionCreate = func(sess Session) { sessC <- sess }
+	go func() {
+		assert.ErrorIs(t, server.Run("localhost:0"), ErrServerStopped)
+	}()
+	defer func() { assert.NoError(t, server.Stop()) }()
+	<-server.acceptingC
+
+	cli, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer func() { assert.NoError(t, cli.Close()) }()
+
+	// response of handler
+	reqBytes, err := server.Packer.Pack(NewMessage(1, []byte("ping")))
+	assert.NoError(t, err)
+	_, err = cli.Write(reqBytes)
+	assert.NoError(t, err)
+	msg, err := server.Packer.Unpack(cli)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("signed:pong"), msg.Data())
+
+	// pushed outside of handler
+	sess := <-sessC
+	assert.True(t, sess.AllocateContext().SetResponseMessage(NewMessage(3, []byte("push"))).Send())
+	msg, err = server.Packer.Unpack(cli)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("signed:push"), msg.Data())
+}
+
+func TestServer_handlerContextCanceledOnDisconnect(t *testing.T) {
+	server := NewServer(&ServerOption{DoNotPrintRoutes: true, AsyncRouter: true})
+	started, canceled := make(chan struct{}), make(chan struct{})
+	server.AddRoute(1, func(ctx Context) {
+		close(started)
+		<-ctx.Done()
+		close(canceled)
+	})
+	go func() {
+		assert.ErrorIs(t, server.Run("localhost:0"), ErrServerStopped)
+	}()
+	defer func() { assert.NoError(t, server.Stop()) }()
+	<-server.acceptingC
+
+	cli, err := net.Dial("tcp", server.Listener.Addr().String())
+	assert.NoError(t, err)
+	reqBytes, err := server.Packer.Pack(NewMessage(1, nil))
+	assert.NoError(t, err)
+	_, err = cli.Write(reqBytes)
+	assert.NoError(t, err)
+	<-started
+	assert.NoError(t, cli.Close())
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("handler context is not canceled")
+	}
+}
@@ -1,11 +1,13 @@
 package easytcp
 
 import (
+	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,8 +29,13 @@ type Session interface {
 	Close()
 
 	// AllocateContext gets a Context ships with current session.
+	// The Context is derived from the session's Context, which is canceled when the session's closed.
 	AllocateContext() Context
 
+	// Context returns the context of the session, which is canceled when the session's closed.
+	// It's useful to stop the background goroutines tied to the session.
+	Context() context.Context
+
 	// Conn returns the underlined connection.
 	Conn() net.Conn
 
@@ -37,28 +44,135 @@ type Session interface {
 
 	// AfterCloseHook blocks until session's on-close hook triggered.
 	AfterCloseHook() <-chan struct{}
+
+	// RemoteAddr returns the remote network address.
+	RemoteAddr() net.Addr
+
+	// LocalAddr returns the local network address.
+	LocalAddr() net.Addr
+
+	// CreatedAt returns the time when session's created.
+	CreatedAt() time.Time
+
+	// LastReadAt returns the time when the last inbound message's read.
+	LastReadAt() time.Time
+
+	// LastWriteAt returns the time when the last outbound message's written.
+	LastWriteAt() time.Time
+
+	// Stats returns the traffic statistics of current session.
+	Stats() SessionStats
+
+	// PauseRead stops reading inbound messages until ResumeRead is called.
+	PauseRead()
+
+	// ResumeRead resumes reading inbound messages.
+	ResumeRead()
+
+	// ResumeToken returns the token to resume current session after the connection's lost.
+	// Returns empty string if session resumption is not enabled.
+	ResumeToken() string
+
+	// Call sends a request message to the client, and waits for the reply.
+	// It can't be called in the goroutine reading inbound messages, e.g. by the handlers when the router's not async,
+	// since the reply could never be read.
+	Call(ctx context.Context, id, req, resp interface{}) error
+
+	// SendReliable sends msg and retransmits it until the client acks.
+	// Returns false without sending if the server has no AckMessageID.
+	SendReliable(msg *Message) (deliveryID uint64, ok bool)
+
+	// ProtocolVersion returns the protocol version negotiated, 0 means not negotiated.
+	ProtocolVersion() int
+
+	// SetProtocolVersion sets the protocol version negotiated,
+	// which decides the versioned routes to handle the messages.
+	SetProtocolVersion(version int)
+}
+
+// SessionStats is the traffic statistics of a session.
+type SessionStats struct {
+	FramesIn  uint64 // number of inbound messages.
+	FramesOut uint64 // number of outbound messages.
+	BytesIn   uint64 // number of bytes read from connection.
+	BytesOut  uint64 // number of bytes written to connection.
+	QueueLen  int    // number of outbound messages waiting to be written.
 }
 
 type session struct {
-	id               interface{}   // session's ID.
-	conn             net.Conn      // tcp connection
-	closedC          chan struct{} // to close when read/write loop stopped
-	closeOnce        sync.Once     // ensure one session only close once
-	afterCreateHookC chan struct{} // to close after session's on-create hook triggered
-	afterCloseHookC  chan struct{} // to close after session's on-close hook triggered
-	respStream       chan Context  // response queue channel, pushed in Send() and popped in writeOutbound()
-	packer           Packer        // to pack and unpack message
-	codec            Codec         // encode/decode message data
-	ctxPool          sync.Pool     // router context pool
-	asyncRouter      bool          // calls router HandlerFunc in a goroutine if false
+	framesIn         uint64                                      // number of inbound messages, accessed atomically
+	framesOut        uint64                                      // number of outbound messages, accessed atomically
+	bytesIn          uint64                                      // number of bytes read, accessed atomically
+	bytesOut         uint64                                      // number of bytes written, accessed atomically
+	lastReadAt       int64                                       // unix nano of the last read, accessed atomically
+	lastWriteAt      int64                                       // unix nano of the last write, accessed atomically
+	deliverySeq      uint64                                      // the last delivery ID of SendReliable, accessed atomically
+	readerID         uint64                                      // ID of the goroutine reading inbound messages, accessed atomically
+	protocolVersion  int64                                       // the protocol version negotiated, accessed atomically
+	seq              uint32                                      // the last sequence ID of Call, accessed atomically
+	id               interface{}                                 // session's ID.
+	conn             net.Conn                                    // tcp connection
+	reader           net.Conn                                    // reads from conn and counts bytesIn
+	createdAt        time.Time                                   // when session's created
+	closedC          chan struct{}                               // to close when read/write loop stopped
+	closeOnce        sync.Once                                   // ensure one session only close once
+	ctx              context.Context                             // canceled when the session's closed
+	cancel           context.CancelFunc                          // cancels ctx
+	afterCreateHookC chan struct{}                               // to close after session's on-create hook triggered
+	afterCloseHookC  chan struct{}                               // to close after session's on-close hook triggered
+	respStream       chan Context                                // response queue channel, pushed in Send() and popped in writeOutbound()
+	packer           Packer                                      // to pack and unpack message
+	codec            Codec                                       // encode/decode message data
+	ctxPool          sync.Pool                                   // router context pool
+	asyncRouter      bool                                        // calls router HandlerFunc in a goroutine if false
+	limiter          *rateLimiter                                // limits inbound messages, nil means no limit
+	msgRateLimits    map[interface{}]*RateLimit                  // rate limits for specific message IDs
+	msgLimiters      map[interface{}]*rateLimiter                // limiters created from msgRateLimits, only used in readInbound
+	pool             *workerPool                                 // runs router HandlerFunc when asyncRouter is true, nil means a goroutine per message
+	ordered          *orderedQueue                               // runs router HandlerFunc in order of orderKey, nil means not ordered
+	orderKey         func(msg *Message) interface{}              // extracts the key of ordered, nil means one key per session
+	pauseMu          sync.Mutex                                  // guards resumeC
+	resumeC          chan struct{}                               // to close when reading's resumed, nil means not paused
+	resumeToken      string                                      // token to resume the session, empty means not resumable
+	connMu           sync.Mutex                                  // guards conn, reader and connLostC when attaching a new connection
+	connLostC        chan struct{}                               // to close when the connection's lost, only for resumable session
+	owner            chan struct{}                               // holds a token while the session's owned by a connection or is being closed
+	resumedC         chan struct{}                               // to close when a detached session's resumed
+	resumeMessageID  interface{}                                 // ID of the resume message, only for resumable session
+	resume           func(token string, conn net.Conn) *session  // resumes the session of token with conn, nil means not resumable
+	takeoverC        chan *session                               // receives the session resumed by the connection
+	callsMu          sync.Mutex                                  // guards calls
+	calls            map[uint32]chan *Message                    // maps sequence ID to the reply channel of Call
+	deliveriesMu     sync.Mutex                                  // guards deliveries
+	deliveries       map[uint64]*delivery                        // messages sent by SendReliable and not acked yet
+	retryOnce        sync.Once                                   // ensure retryLoop only starts once
+	ackMessageID     interface{}                                 // ID of the ack message for SendReliable
+	retryInterval    time.Duration                               // interval to retransmit messages not acked, 0 means only on resume
+	maxRetries       int                                         // max retransmissions of a message, 0 means no limit
+	onDeliveryFailed func(sess Session, msg *Message, err error) // hook invoked when a message fails to be delivered
+	outbound         HandlerFunc                                 // runs the outbound middlewares before packing, can be nil
 }
 
 // sessionOption is the extra options for session.
 type sessionOption struct {
-	Packer        Packer
-	Codec         Codec
-	respQueueSize int
-	asyncRouter   bool
+	Packer           Packer
+	Codec            Codec
+	respQueueSize    int
+	asyncRouter      bool
+	rateLimit        *RateLimit
+	msgRateLimits    map[interface{}]*RateLimit
+	pool             *workerPool
+	orderedRouter    bool
+	orderKey         func(msg *Message) interface{}
+	orderedQueueSize int
+	resumable        bool
+	resumeMessageID  interface{}
+	resume           func(token string, conn net.Conn) *session
+	ackMessageID     interface{}
+	retryInterval    time.Duration
+	maxRetries       int
+	onDeliveryFailed func(sess Session, msg *Message, err error)
+	outbound         HandlerFunc
 }
 
 // newSession creates a new session.
@@ -66,10 +180,18 @@ type sessionOption struct {
 // opt includes packer, codec, and channel size.
 // Returns a session pointer.
 func newSession(conn net.Conn, opt *sessionOption) *session {
-	return &session{
+	var limiter *rateLimiter
+	if opt.rateLimit != nil {
+		limiter = newRateLimiter(opt.rateLimit)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &session{
 		id:               uuid.NewString(), // use uuid as default
 		conn:             conn,
+		createdAt:        time.Now(),
 		closedC:          make(chan struct{}),
+		ctx:              ctx,
+		cancel:           cancel,
 		afterCreateHookC: make(chan struct{}),
 		afterCloseHookC:  make(chan struct{}),
 		respStream:       make(chan Context, opt.respQueueSize),
@@ -77,7 +199,35 @@ func newSession(conn net.Conn, opt *sessionOption) *session {
 		codec:            opt.Codec,
 		ctxPool:          sync.Pool{New: func() interface{} { return newContext() }},
 		asyncRouter:      opt.asyncRouter,
+		limiter:          limiter,
+		msgRateLimits:    opt.msgRateLimits,
+		msgLimiters:      make(map[interface{}]*rateLimiter),
+		pool:             opt.pool,
+		orderKey:         opt.orderKey,
+		ackMessageID:     opt.ackMessageID,
+		retryInterval:    opt.retryInterval,
+		maxRetries:       opt.maxRetries,
+		onDeliveryFailed: opt.onDeliveryFailed,
+		outbound:         opt.outbound,
 	}
+	sess.reader = &countingConn{Conn: conn, n: &sess.bytesIn}
+	if opt.orderedRouter {
+		size := opt.orderedQueueSize
+		if size <= 0 {
+			size = DefaultOrderedQueueSize
+		}
+		sess.ordered = newOrderedQueue(opt.pool, size, sess.closedC)
+	}
+	if opt.resumable {
+		sess.resumeToken = uuid.NewString()
+		sess.connLostC = make(chan struct{})
+		sess.owner = make(chan struct{}, 1)
+		sess.owner <- struct{}{} // owned by the connection
+		sess.resumeMessageID = opt.resumeMessageID
+		sess.resume = opt.resume
+		sess.takeoverC = make(chan *session, 1)
+	}
+	return sess
 }
 
 // ID returns the session's id.
@@ -94,6 +244,9 @@ func (s *session) SetID(id interface{}) {
 // Send pushes response message to respStream.
 // Returns false if session is closed or ctx is done.
 func (s *session) Send(ctx Context) (ok bool) {
+	if ctx.Err() != nil { // canceled or timed out
+		return false
+	}
 	select {
 	case <-ctx.Done():
 		return false
@@ -112,7 +265,15 @@ func (s *session) Codec() Codec {
 // Close closes the session, but doesn't close the connection.
 // The connection will be closed in the server once the session's closed.
 func (s *session) Close() {
-	s.closeOnce.Do(func() { close(s.closedC) })
+	s.closeOnce.Do(func() {
+		close(s.closedC)
+		s.cancel()
+	})
+}
+
+// Context returns the context of the session, which is canceled when the session's closed.
+func (s *session) Context() context.Context {
+	return s.ctx
 }
 
 // AfterCreateHook blocks until session's on-create hook triggered.
@@ -130,31 +291,137 @@ func (s *session) AllocateContext() Context {
 	c := s.ctxPool.Get().(*routeContext)
 	c.reset()
 	c.SetSession(s)
+	c.rawCtx = s.ctx
+	c.pooled = true
 	return c
 }
 
 // Conn returns the underlined connection instance.
+// For a resumed session, it's the latest connection.
 func (s *session) Conn() net.Conn {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
 	return s.conn
 }
 
+// RemoteAddr returns the remote network address of the connection.
+// When the connection is accepted by a PROXY protocol aware listener,
+// it's the address of the original client.
+func (s *session) RemoteAddr() net.Addr {
+	conn := s.Conn()
+	if conn == nil {
+		return nil
+	}
+	return conn.RemoteAddr()
+}
+
+// LocalAddr returns the local network address of the connection.
+func (s *session) LocalAddr() net.Addr {
+	conn := s.Conn()
+	if conn == nil {
+		return nil
+	}
+	return conn.LocalAddr()
+}
+
+// CreatedAt returns the time when session's created.
+func (s *session) CreatedAt() time.Time {
+	return s.createdAt
+}
+
+// LastReadAt returns the time when the last inbound message's read.
+// Returns zero time if nothing's read.
+func (s *session) LastReadAt() time.Time {
+	return unixNanoTime(atomic.LoadInt64(&s.lastReadAt))
+}
+
+// LastWriteAt returns the time when the last outbound message's written.
+// Returns zero time if nothing's written.
+func (s *session) LastWriteAt() time.Time {
+	return unixNanoTime(atomic.LoadInt64(&s.lastWriteAt))
+}
+
+// Stats returns the traffic statistics of current session.
+func (s *session) Stats() SessionStats {
+	return SessionStats{
+		FramesIn:  atomic.LoadUint64(&s.framesIn),
+		FramesOut: atomic.LoadUint64(&s.framesOut),
+		BytesIn:   atomic.LoadUint64(&s.bytesIn),
+		BytesOut:  atomic.LoadUint64(&s.bytesOut),
+		QueueLen:  len(s.respStream),
+	}
+}
+
+// PauseRead stops reading inbound messages until ResumeRead is called.
+// The message being read when PauseRead is called will still be handled.
+// While paused, the client is pushed back by TCP flow control.
+func (s *session) PauseRead() {
+	s.pauseMu.Lock()
+	if s.resumeC == nil {
+		s.resumeC = make(chan struct{})
+	}
+	s.pauseMu.Unlock()
+}
+
+// ResumeRead resumes reading inbound messages.
+func (s *session) ResumeRead() {
+	s.pauseMu.Lock()
+	if s.resumeC != nil {
+		close(s.resumeC)
+		s.resumeC = nil
+	}
+	s.pauseMu.Unlock()
+}
+
+// waitResumed blocks while reading is paused.
+// Returns false if the session is closed, or the connection's lost.
+func (s *session) waitResumed() bool {
+	s.pauseMu.Lock()
+	resumeC := s.resumeC
+	s.pauseMu.Unlock()
+	if resumeC == nil {
+		return true
+	}
+	_, _, lostC := s.connection()
+	select {
+	case <-resumeC:
+		return true
+	case <-s.closedC:
+		return false
+	case <-lostC:
+		return false
+	}
+}
+
+// ResumeToken returns the token to resume current session.
+func (s *session) ResumeToken() string {
+	return s.resumeToken
+}
+
 // readInbound reads message packet from connection in a loop.
 // And send unpacked message to reqQueue, which will be consumed in router.
 // The loop breaks if errors occurred or the session is closed.
 func (s *session) readInbound(router *Router, timeout time.Duration) {
+	conn, reader, lostC := s.connection()
+	atomic.StoreUint64(&s.readerID, goroutineID())
 	for {
 		select {
 		case <-s.closedC:
 			return
+		case <-lostC:
+			return
 		default:
 		}
+		if !s.waitResumed() {
+			return
+		}
 		if timeout > 0 {
-			if err := s.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
 				_log.Errorf("session %s set read deadline err: %s", s.id, err)
 				break
 			}
 		}
-		reqMsg, err := s.packer.Unpack(s.conn)
+		reqMsg, err := s.packer.Unpack(reader)
 		if err != nil {
 			logMsg := fmt.Sprintf("session %s unpack inbound packet err: %s", s.id, err)
 			if err == io.EOF {
@@ -167,20 +434,121 @@ func (s *session) readInbound(router *Router, timeout time.Duration) {
 		if reqMsg == nil {
 			continue
 		}
-
-		if s.asyncRouter {
-			go s.handleReq(router, reqMsg)
-		} else {
-			s.handleReq(router, reqMsg)
+		if s.takeover(reqMsg) {
+			return
 		}
+		s.handleInbound(router, reqMsg)
 	}
 	_log.Tracef("session %s readInbound exit because of error", s.id)
-	s.Close()
+	s.loseConn(lostC)
+}
+
+// handleInbound counts the unpacked reqMsg, passes it through the filters, checks the rate limit,
+// and dispatches it to the router if it's not a reply of Call nor an ack of SendReliable.
+func (s *session) handleInbound(router *Router, reqMsg *Message) {
+	atomic.AddUint64(&s.framesIn, 1)
+	atomic.StoreInt64(&s.lastReadAt, time.Now().UnixNano())
+	if reqMsg = router.filterInbound(s, reqMsg); reqMsg == nil {
+		return
+	}
+	if !s.allowInbound(reqMsg) {
+		return
+	}
+	if s.deliverReply(reqMsg) || s.deliverAck(reqMsg) {
+		return
+	}
+	s.dispatch(router, reqMsg)
+}
+
+// allowInbound checks reqMsg against the rate limit, and takes the RateLimitAction when it's exceeded.
+// Returns false if reqMsg should not be handled.
+func (s *session) allowInbound(reqMsg *Message) bool {
+	limiter := s.rateLimiterOf(reqMsg.ID())
+	if limiter == nil {
+		return true
+	}
+	size := len(reqMsg.Data())
+	if limiter.limit.Action == RateLimitDelay {
+		wait := limiter.reserve(time.Now(), size, true)
+		if wait <= 0 {
+			return true
+		}
+		_, _, lostC := s.connection()
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-s.closedC:
+			return false
+		case <-lostC:
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+	if limiter.reserve(time.Now(), size, false) <= 0 {
+		return true
+	}
+	_log.Tracef("session %s inbound message %v exceeds rate limit", s.id, reqMsg.ID())
+	switch limiter.limit.Action {
+	case RateLimitReply:
+		if limiter.limit.ReplyMessage == nil {
+			break
+		}
+		if respMsg := limiter.limit.ReplyMessage(reqMsg); respMsg != nil {
+			s.AllocateContext().SetResponseMessage(respMsg).Send()
+		}
+	case RateLimitDisconnect:
+		s.Close()
+	}
+	return false
+}
+
+// rateLimiterOf returns the limiter for message id.
+// The limiter of msgRateLimits is preferred, returns nil if there's no limit.
+func (s *session) rateLimiterOf(id interface{}) *rateLimiter {
+	id = normalizeID(id)
+	limit, has := s.msgRateLimits[id]
+	if !has {
+		return s.limiter
+	}
+	limiter, has := s.msgLimiters[id]
+	if !has {
+		limiter = newRateLimiter(limit)
+		s.msgLimiters[id] = limiter
+	}
+	return limiter
+}
+
+// dispatch handles reqMsg in current goroutine, or in a worker of pool, or in a new goroutine
+// according to the session options.
+// Blocks when the worker pool or the ordered queue is saturated, which pushes back on the read loop.
+func (s *session) dispatch(router *Router, reqMsg *Message) {
+	if s.ordered != nil {
+		var key interface{}
+		if s.orderKey != nil {
+			key = s.orderKey(reqMsg)
+		}
+		s.ordered.push(key, func() { s.handleReq(router, reqMsg) })
+		return
+	}
+	if !s.asyncRouter {
+		s.handleReq(router, reqMsg)
+		return
+	}
+	if s.pool == nil {
+		go s.handleReq(router, reqMsg)
+		return
+	}
+	s.pool.submit(func() { s.handleReq(router, reqMsg) }, s.closedC)
 }
 
 func (s *session) handleReq(router *Router, reqMsg *Message) {
 	ctx := s.AllocateContext().SetRequestMessage(reqMsg)
 	router.handleRequest(ctx)
+	if streamed(ctx) { // responded by the stream
+		s.releaseContext(ctx)
+		return
+	}
 	s.Send(ctx)
 }
 
@@ -188,11 +556,14 @@ func (s *session) handleReq(router *Router, reqMsg *Message) {
 // Parameter writeTimeout specified the connection writing timeout.
 // The loop breaks if errors occurred, or the session is closed.
 func (s *session) writeOutbound(writeTimeout time.Duration) {
+	conn, _, lostC := s.connection()
 	for {
 		var ctx Context
 		select {
 		case <-s.closedC:
 			return
+		case <-lostC:
+			return
 		case ctx = <-s.respStream:
 		}
 
@@ -206,25 +577,67 @@ func (s *session) writeOutbound(writeTimeout time.Duration) {
 		}
 
 		if writeTimeout > 0 {
-			if err := s.conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+			if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
 				_log.Errorf("session %s set write deadline err: %s", s.id, err)
 				break
 			}
 		}
 
-		if _, err := s.conn.Write(outboundBytes); err != nil {
+		n, err := conn.Write(outboundBytes)
+		atomic.AddUint64(&s.bytesOut, uint64(n))
+		if err != nil {
 			_log.Errorf("session %s conn write err: %s", s.id, err)
 			break
 		}
+		atomic.AddUint64(&s.framesOut, 1)
+		atomic.StoreInt64(&s.lastWriteAt, time.Now().UnixNano())
 	}
-	s.Close()
+	s.loseConn(lostC)
 	_log.Tracef("session %s writeOutbound exit because of error", s.id)
 }
 
 func (s *session) packResponse(ctx Context) ([]byte, error) {
-	defer s.ctxPool.Put(ctx)
+	defer s.releaseContext(ctx)
 	if ctx.Response() == nil {
 		return nil, nil
 	}
+	if s.outbound != nil {
+		s.outbound(ctx)
+		if ctx.Response() == nil { // dropped by outbound middlewares
+			return nil, nil
+		}
+	}
 	return s.packer.Pack(ctx.Response())
 }
+
+// releaseContext puts ctx back to ctxPool if it's allocated from ctxPool.
+// The others, like the detached ones, are left to their owners.
+// So are the ones whose underline context might be derived from themselves,
+// since they can be reached through the contexts derived from them.
+func (s *session) releaseContext(ctx Context) {
+	if c, ok := ctx.(*routeContext); ok && c.pooled {
+		c.release(&s.ctxPool)
+	}
+}
+
+// countingConn reads from the connection and adds the number of bytes read to n.
+// It keeps the net.Conn interface, in case the packer needs the connection.
+type countingConn struct {
+	net.Conn
+	n *uint64
+}
+
+// Read implements the net.Conn Read method.
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+// unixNanoTime converts unix nano to time.Time, 0 is converted to zero time.
+func unixNanoTime(nano int64) time.Time {
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
@@ -1,6 +1,7 @@
 package easytcp
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/DarthPestilane/easytcp/internal/mock"
@@ -9,6 +10,7 @@ import (
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -319,6 +321,62 @@ func TestTCPSession_writeOutbound(t *testing.T) {
 		sess.Close()
 		<-done
 	})
+	t.Run("when connection is lost while paused", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{resumable: true})
+		sess.PauseRead()
+		_, _, lostC := sess.connection()
+		sess.loseConn(lostC)
+		assert.False(t, sess.waitResumed())
+		assert.False(t, sess.isClosed()) // waiting to be resumed
+	})
+}
+
+func Test_session_packResponse_outbound(t *testing.T) {
+	sess := newSession(nil, &sessionOption{
+		Packer: NewDefaultPacker(),
+		outbound: func(ctx Context) {
+			if ctx.Response().ID() == 1 {
+				ctx.SetResponseMessage(nil) // drop
+				return
+			}
+			ctx.SetResponseMessage(NewMessage(ctx.Response().ID(), []byte("rewritten")))
+		},
+	})
+	data, err := sess.packResponse(sess.AllocateContext().SetResponseMessage(NewMessage(1, []byte("test"))))
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+
+	data, err = sess.packResponse(sess.AllocateContext().SetResponseMessage(NewMessage(2, []byte("test"))))
+	assert.NoError(t, err)
+	msg, err := sess.packer.Unpack(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("rewritten"), msg.Data())
+}
+
+func Test_session_packResponse_detached(t *testing.T) {
+	sess := newSession(nil, &sessionOption{Packer: NewDefaultPacker()})
+	ctx := sess.AllocateContext().SetResponseMessage(NewMessage(1, []byte("test")))
+	ctx.Set("key", "value")
+	detached := ctx.Detach()
+
+	_, err := sess.packResponse(detached)
+	assert.NoError(t, err)
+	v, ok := detached.Get("key") // not released
+	assert.True(t, ok)
+	assert.Equal(t, "value", v)
+}
+
+func TestTCPSession_Context(t *testing.T) {
+	sess := newSession(nil, &sessionOption{})
+	ctx := sess.AllocateContext()
+	assert.NoError(t, sess.Context().Err())
+	assert.NoError(t, ctx.Err())
+
+	sess.Close()
+	<-sess.Context().Done()
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	assert.False(t, ctx.Send())
 }
 
 func Test_session_SetID(t *testing.T) {
@@ -337,3 +395,112 @@ func Test_session_Conn(t *testing.T) {
 	s := newSession(conn, &sessionOption{})
 	assert.Equal(t, s.Conn(), conn)
 }
+
+func Test_session_Addr(t *testing.T) {
+	sess := newSession(nil, &sessionOption{})
+	assert.Nil(t, sess.RemoteAddr())
+	assert.Nil(t, sess.LocalAddr())
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}
+	local := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5678}
+	conn := mock.NewMockConn(ctrl)
+	conn.EXPECT().RemoteAddr().Return(remote)
+	conn.EXPECT().LocalAddr().Return(local)
+	sess = newSession(conn, &sessionOption{})
+	assert.Equal(t, remote, sess.RemoteAddr())
+	assert.Equal(t, local, sess.LocalAddr())
+}
+
+func Test_session_Stats(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	packer := NewDefaultPacker()
+	p1, p2 := net.Pipe()
+	sess := newSession(p1, &sessionOption{Packer: packer, respQueueSize: 10})
+	assert.False(t, sess.CreatedAt().IsZero())
+	assert.True(t, sess.LastReadAt().IsZero())
+	assert.True(t, sess.LastWriteAt().IsZero())
+
+	r := newRouter()
+	r.register(1, func(ctx Context) {
+		ctx.SetResponseMessage(NewMessage(2, []byte("pong")))
+	})
+	go sess.readInbound(r, 0)
+	go sess.writeOutbound(0)
+
+	reqBytes, err := packer.Pack(NewMessage(1, []byte("ping")))
+	assert.NoError(t, err)
+	_, err = p2.Write(reqBytes)
+	assert.NoError(t, err)
+	respMsg, err := packer.Unpack(p2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("pong"), respMsg.Data())
+
+	time.Sleep(time.Millisecond * 5) // wait for the counters
+	stats := sess.Stats()
+	assert.EqualValues(t, 1, stats.FramesIn)
+	assert.EqualValues(t, 1, stats.FramesOut)
+	assert.EqualValues(t, len(reqBytes), stats.BytesIn)
+	assert.EqualValues(t, 12, stats.BytesOut)
+	assert.Zero(t, stats.QueueLen)
+	assert.False(t, sess.LastReadAt().IsZero())
+	assert.False(t, sess.LastWriteAt().IsZero())
+
+	sess.Close()
+	_ = p2.Close()
+}
+
+func Test_session_PauseRead(t *testing.T) {
+	t.Run("when paused and resumed", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		var count int32
+		packer := NewMockPacker(ctrl)
+		packer.EXPECT().Unpack(gomock.Any()).AnyTimes().DoAndReturn(func(_ io.Reader) (*Message, error) {
+			if atomic.AddInt32(&count, 1) > 2 {
+				return nil, fmt.Errorf("unpack error")
+			}
+			return NewMessage(1, []byte("test")), nil
+		})
+
+		r := newRouter()
+		r.register(1, func(ctx Context) {
+			ctx.Session().PauseRead()
+		})
+
+		sess := newSession(nil, &sessionOption{Packer: packer, respQueueSize: 10})
+		sess.PauseRead() // pause twice is fine
+		sess.ResumeRead()
+		done := make(chan struct{})
+		go func() {
+			sess.readInbound(r, 0)
+			close(done)
+		}()
+		time.Sleep(time.Millisecond * 10)
+		assert.EqualValues(t, 1, atomic.LoadInt32(&count)) // paused after the first message
+
+		sess.ResumeRead()
+		time.Sleep(time.Millisecond * 10)
+		assert.EqualValues(t, 2, atomic.LoadInt32(&count)) // paused after the second message
+
+		sess.ResumeRead()
+		<-done
+		assert.EqualValues(t, 3, atomic.LoadInt32(&count))
+	})
+	t.Run("when session is closed while paused", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{})
+		sess.PauseRead()
+		done := make(chan struct{})
+		go func() {
+			sess.readInbound(nil, 0)
+			close(done)
+		}()
+		sess.Close()
+		<-done
+	})
+}
@@ -0,0 +1,126 @@
+package easytcp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// MessageStreamEndKey is the Message storage key marking the end of the response messages sent by Stream.
+// To support streaming, the Packer should write the mark into the packet in Pack,
+// so that the client knows there're no more responses for the request.
+const MessageStreamEndKey = "easytcp.stream_end"
+
+// ErrStreamEnded is returned when sending to an ended Stream.
+var ErrStreamEnded = fmt.Errorf("stream ended")
+
+// Stream sends multiple response messages for a request, followed by an end-of-stream message.
+// The response messages carry the sequence ID of the request in storage with MessageSeqKey, if there's one.
+// Sending blocks when the session's response queue is full,
+// and fails when the request Context is done or the session's closed.
+// It doesn't refer to the request Context, and it's safe for concurrent use, even after the handler returns,
+// unless the handler timed out, see ServerOption.HandlerTimeout.
+type Stream interface {
+	// Send encodes data with session's codec and sends it as a response message of id.
+	Send(id, data interface{}) error
+
+	// SendMessage sends msg as a response message.
+	SendMessage(msg *Message) error
+
+	// End sends an empty message with the request's ID, marked with MessageStreamEndKey.
+	// Sending after End returns ErrStreamEnded, and calling End again does nothing.
+	End() error
+}
+
+var _ Stream = &stream{} // implementation check
+
+// stream implements the Stream interface.
+// It keeps what it needs of the request Context, which is put back to pool after the handler returns.
+type stream struct {
+	used    int32           // whether anything's sent, accessed atomically
+	session Session         // session of the request
+	reqID   interface{}     // ID of the request message
+	seq     interface{}     // sequence ID of the request message, nil means none
+	raw     context.Context // underline context of the request Context, done along with the request or when the handler times out
+	mu      sync.Mutex
+	ended   bool
+}
+
+// newStream creates a stream for the request of ctx.
+func newStream(ctx Context) *stream {
+	reqMsg := ctx.Request()
+	seq, _ := reqMsg.Get(MessageSeqKey)
+	return &stream{
+		session: ctx.Session(),
+		reqID:   reqMsg.ID(),
+		seq:     seq,
+		raw:     ctx.RawContext(),
+	}
+}
+
+// Send implements Stream.Send method.
+func (s *stream) Send(id, data interface{}) error {
+	codec := s.session.Codec()
+	if codec == nil {
+		return fmt.Errorf("codec is nil")
+	}
+	dataBytes, err := codec.Encode(data)
+	if err != nil {
+		return err
+	}
+	return s.SendMessage(NewMessage(id, dataBytes))
+}
+
+// SendMessage implements Stream.SendMessage method.
+func (s *stream) SendMessage(msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return ErrStreamEnded
+	}
+	return s.send(msg)
+}
+
+// End implements Stream.End method.
+func (s *stream) End() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return nil
+	}
+	s.ended = true
+	msg := NewMessage(s.reqID, nil)
+	msg.Set(MessageStreamEndKey, true)
+	return s.send(msg)
+}
+
+// send sends msg in a new Context of the session, which is done along with the request Context.
+func (s *stream) send(msg *Message) error {
+	atomic.StoreInt32(&s.used, 1)
+	if s.seq != nil {
+		if _, set := msg.Get(MessageSeqKey); !set {
+			msg.Set(MessageSeqKey, s.seq)
+		}
+	}
+	if !s.session.AllocateContext().WithContext(s.raw).SetResponseMessage(msg).Send() {
+		if err := s.raw.Err(); err != nil && s.session.Context().Err() == nil {
+			return err // the request's canceled or timed out
+		}
+		return ErrSessionClosed
+	}
+	return nil
+}
+
+// streamed returns whether the Stream of ctx has sent anything,
+// in which case the response of ctx is not sent, since it'd come after the stream.
+func streamed(ctx Context) bool {
+	c, ok := ctx.(*routeContext)
+	if !ok {
+		return false
+	}
+	c.mu.RLock()
+	st := c.stream
+	c.mu.RUnlock()
+	return st != nil && atomic.LoadInt32(&st.used) == 1
+}
@@ -0,0 +1,110 @@
+package easytcp
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestContext_Stream(t *testing.T) {
+	sess := newSession(nil, &sessionOption{Codec: &JsonCodec{}, respQueueSize: 10})
+	reqMsg := NewMessage(1, nil)
+	reqMsg.Set(MessageSeqKey, uint32(7))
+	ctx := sess.AllocateContext().SetRequestMessage(reqMsg)
+	st := ctx.Stream()
+	assert.Same(t, st, ctx.Stream())
+
+	assert.NoError(t, st.Send(2, "page 1"))
+	assert.NoError(t, st.SendMessage(NewMessage(2, []byte("page 2"))))
+	assert.Error(t, st.Send(2, func() {})) // encode err
+	assert.NoError(t, st.End())
+	assert.NoError(t, st.End())
+	assert.ErrorIs(t, st.Send(2, "page 3"), ErrStreamEnded)
+	assert.ErrorIs(t, st.SendMessage(NewMessage(2, nil)), ErrStreamEnded)
+
+	assert.Len(t, sess.respStream, 3)
+	for _, data := range []string{`"page 1"`, "page 2"} {
+		msg := (<-sess.respStream).Response()
+		assert.Equal(t, 2, msg.ID())
+		assert.Equal(t, data, string(msg.Data()))
+		assert.Equal(t, uint32(7), msg.MustGet(MessageSeqKey))
+		_, ended := msg.Get(MessageStreamEndKey)
+		assert.False(t, ended)
+	}
+	endMsg := (<-sess.respStream).Response()
+	assert.Equal(t, 1, endMsg.ID())
+	assert.Empty(t, endMsg.Data())
+	assert.Equal(t, true, endMsg.MustGet(MessageStreamEndKey))
+	assert.Equal(t, uint32(7), endMsg.MustGet(MessageSeqKey))
+}
+
+func TestContext_Stream_codecNil(t *testing.T) {
+	sess := newSession(nil, &sessionOption{respQueueSize: 10})
+	ctx := sess.AllocateContext().SetRequestMessage(NewMessage(1, nil))
+	assert.Error(t, ctx.Stream().Send(2, "test"))
+}
+
+func TestContext_Stream_backpressure(t *testing.T) {
+	sess := newSession(nil, &sessionOption{respQueueSize: 1})
+	ctx := sess.AllocateContext().SetRequestMessage(NewMessage(1, nil))
+	c, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	ctx.WithContext(c)
+
+	st := ctx.Stream()
+	assert.NoError(t, st.SendMessage(NewMessage(2, nil)))
+	// blocks on the full queue until the request's done
+	assert.ErrorIs(t, st.SendMessage(NewMessage(2, nil)), context.DeadlineExceeded)
+	assert.Len(t, sess.respStream, 1)
+}
+
+func TestContext_Stream_sessionClosed(t *testing.T) {
+	sess := newSession(nil, &sessionOption{respQueueSize: 1})
+	ctx := sess.AllocateContext().SetRequestMessage(NewMessage(1, nil))
+	sess.Close()
+	assert.ErrorIs(t, ctx.Stream().SendMessage(NewMessage(2, nil)), ErrSessionClosed)
+	assert.ErrorIs(t, ctx.Stream().End(), ErrSessionClosed)
+}
+
+func TestContext_Stream_afterReleased(t *testing.T) {
+	sess := newSession(nil, &sessionOption{respQueueSize: 10})
+	reqMsg := NewMessage(1, nil)
+	reqMsg.Set(MessageSeqKey, uint32(7))
+	ctx := sess.AllocateContext().SetRequestMessage(reqMsg)
+	st := ctx.Stream()
+	sess.releaseContext(ctx) // the handler returned
+
+	// the Context is reused by another request
+	sess.AllocateContext().SetRequestMessage(NewMessage(3, nil))
+
+	assert.NoError(t, st.SendMessage(NewMessage(2, nil)))
+	assert.NoError(t, st.End())
+	assert.Equal(t, uint32(7), (<-sess.respStream).Response().MustGet(MessageSeqKey))
+	endMsg := (<-sess.respStream).Response()
+	assert.Equal(t, 1, endMsg.ID())
+	assert.Equal(t, uint32(7), endMsg.MustGet(MessageSeqKey))
+}
+
+func TestTCPSession_handleReq_streamed(t *testing.T) {
+	r := newRouter()
+	r.register(1, func(ctx Context) {
+		assert.NoError(t, ctx.Stream().SendMessage(NewMessage(2, nil)))
+		assert.NoError(t, ctx.Stream().End())
+		ctx.SetResponseMessage(NewMessage(3, nil))
+	})
+	r.register(4, func(ctx Context) {
+		ctx.Stream() // not used
+		ctx.SetResponseMessage(NewMessage(5, nil))
+	})
+
+	sess := newSession(nil, &sessionOption{respQueueSize: 10})
+	sess.handleReq(r, NewMessage(1, nil))
+	assert.Len(t, sess.respStream, 2) // the response of handler is not sent after the stream
+	assert.Equal(t, 2, (<-sess.respStream).Response().ID())
+	assert.Equal(t, 1, (<-sess.respStream).Response().ID())
+
+	sess.handleReq(r, NewMessage(4, nil))
+	assert.Len(t, sess.respStream, 1)
+	assert.Equal(t, 5, (<-sess.respStream).Response().ID())
+}
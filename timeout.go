@@ -0,0 +1,171 @@
+package easytcp
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// timeoutOf returns the handler timeout of the route of id.
+// The default one is returned if there's no timeout for id, <= 0 means no timeout.
+func (t *routeTable) timeoutOf(id interface{}) time.Duration {
+	if d, has := t.timeouts[id]; has {
+		return d
+	}
+	return t.handlerTimeout
+}
+
+// withTimeout wraps handler with a deadline of timeout on the Context.
+// When the deadline exceeds, the timeout handler is called with a detached copy of the Context made at that moment,
+// and the timeout response it sets is sent at once, even if handler ignores the deadline and keeps running.
+// The response and error set by the timed-out handler are dropped after it returns,
+// and the copies and streams made in it are kept done, so that they can't be sent.
+// If handler returns in time, the deadline is removed from the Context and the copies and streams.
+func (t *routeTable) withTimeout(handler HandlerFunc, timeout time.Duration) HandlerFunc {
+	if handler == nil || timeout <= 0 {
+		return handler
+	}
+	timeoutHandler := t.timeoutHandler
+	return func(ctx Context) {
+		sess, reqMsg, raw := ctx.Session(), ctx.Request(), ctx.RawContext()
+		c := newHandlerContext(raw, timeout)
+		timedOutC := make(chan struct{}) // closed once ctx is no longer used by the timer
+		timer := time.AfterFunc(timeout, func() {
+			if !c.timeOut() {
+				return
+			}
+			_log.Tracef("handler of message %v timed out after %s", reqMsg.ID(), timeout)
+			if timeoutHandler == nil {
+				close(timedOutC)
+				return
+			}
+			timeoutCtx := detachTimedOut(ctx, sess, reqMsg, raw)
+			close(timedOutC)
+			timeoutHandler(timeoutCtx)
+			if timeoutCtx.Response() != nil {
+				timeoutCtx.Send()
+			}
+		})
+
+		restore := swapRawContext(ctx, c)
+		handler(ctx)
+		returned := c.finish()
+		timer.Stop()
+		if !returned { // the timeout response is sent instead
+			<-timedOutC // ctx is released after the handler returns
+			ctx.SetResponseMessage(nil)
+			ctx.SetHandlerError(nil)
+		}
+		restore()
+	}
+}
+
+// detachTimedOut returns the Context for the timeout handler, while the timed-out handler may be still using ctx.
+// sess, reqMsg and raw are the ones of ctx when the handler started.
+func detachTimedOut(ctx Context, sess Session, reqMsg *Message, raw context.Context) Context {
+	if c, ok := ctx.(*routeContext); ok {
+		return c.detach(sess, reqMsg, raw)
+	}
+	return ctx.Detach().SetResponseMessage(nil).SetHandlerError(nil)
+}
+
+// states of the handler wrapped by withTimeout.
+const (
+	handlerRunning int32 = iota
+	handlerReturned
+	handlerTimedOut
+)
+
+// handlerContext is the underline context of a handler with a timeout, which is done when the handler times out.
+// Unlike the one of context.WithTimeout, it falls back to the parent once the handler returns in time,
+// so that the copies and streams made in the handler keep working after it returns.
+type handlerContext struct {
+	context.Context // derived from parent, canceled when the handler times out or returns
+	parent          context.Context
+	cancel          context.CancelFunc
+	deadline        time.Time
+	state           int32 // handlerRunning, handlerReturned or handlerTimedOut, accessed atomically
+}
+
+// newHandlerContext creates a handlerContext of parent, whose deadline is timeout later.
+func newHandlerContext(parent context.Context, timeout time.Duration) *handlerContext {
+	c, cancel := context.WithCancel(parent)
+	return &handlerContext{Context: c, parent: parent, cancel: cancel, deadline: time.Now().Add(timeout)}
+}
+
+// timeOut marks the handler timed out, and cancels c.
+// Returns false if the handler has returned.
+func (c *handlerContext) timeOut() bool {
+	if !atomic.CompareAndSwapInt32(&c.state, handlerRunning, handlerTimedOut) {
+		return false
+	}
+	c.cancel()
+	return true
+}
+
+// finish marks the handler returned, and releases c from the parent.
+// Returns false if the handler has timed out.
+func (c *handlerContext) finish() bool {
+	returned := atomic.CompareAndSwapInt32(&c.state, handlerRunning, handlerReturned)
+	c.cancel() // the ones waiting on Done before wake up, and see Err of the parent
+	return returned
+}
+
+// Deadline implements the context.Context Deadline method.
+func (c *handlerContext) Deadline() (time.Time, bool) {
+	deadline, ok := c.parent.Deadline()
+	if atomic.LoadInt32(&c.state) == handlerReturned || (ok && deadline.Before(c.deadline)) {
+		return deadline, ok
+	}
+	return c.deadline, true
+}
+
+// Done implements the context.Context Done method.
+func (c *handlerContext) Done() <-chan struct{} {
+	if atomic.LoadInt32(&c.state) == handlerReturned {
+		return c.parent.Done()
+	}
+	return c.Context.Done()
+}
+
+// Err implements the context.Context Err method.
+func (c *handlerContext) Err() error {
+	switch atomic.LoadInt32(&c.state) {
+	case handlerReturned:
+		return c.parent.Err()
+	case handlerTimedOut:
+		return context.DeadlineExceeded
+	default:
+		return c.Context.Err()
+	}
+}
+
+// swapRawContext sets the underline context of ctx to raw, which wraps the current one,
+// and returns the func to restore the current one.
+func swapRawContext(ctx Context, raw context.Context) (restore func()) {
+	old := ctx.RawContext()
+	ctx.WithContext(raw)
+	return func() { ctx.WithContext(old) }
+}
+
+// setTimeout sets the handler timeout of the route of id.
+func (r *Router) setTimeout(id interface{}, timeout time.Duration) {
+	id = normalizeID(id)
+	r.update(func(t *routeTable) {
+		t.timeouts[id] = timeout
+	})
+}
+
+// setDefaultTimeout sets the handler timeout of the routes without their own.
+func (r *Router) setDefaultTimeout(timeout time.Duration) {
+	r.update(func(t *routeTable) {
+		t.handlerTimeout = timeout
+	})
+}
+
+// setTimeoutHandler sets the handler to set the response when a handler times out.
+func (r *Router) setTimeoutHandler(handler HandlerFunc) {
+	r.update(func(t *routeTable) {
+		t.timeoutHandler = handler
+	})
+}
@@ -0,0 +1,183 @@
+package easytcp
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_routeTable_timeoutOf(t *testing.T) {
+	tb := newRouteTable()
+	assert.Zero(t, tb.timeoutOf(1))
+	tb.handlerTimeout = time.Second
+	tb.timeouts[2] = -1
+	tb.timeouts[3] = time.Minute
+	assert.Equal(t, time.Second, tb.timeoutOf(1))
+	assert.Equal(t, time.Duration(-1), tb.timeoutOf(2))
+	assert.Equal(t, time.Minute, tb.timeoutOf(3))
+
+	assert.Nil(t, tb.withTimeout(nil, time.Second))
+	assert.NotNil(t, tb.withTimeout(nilHandler, -1))
+}
+
+func TestRouter_handleReq_timeout(t *testing.T) {
+	rt := newRouter()
+	rt.setDefaultTimeout(time.Millisecond * 10)
+	rt.setTimeout(2, time.Hour)
+	rt.setTimeout(3, -1)
+	rt.setTimeoutHandler(func(ctx Context) {
+		v, _ := ctx.Get("progress")
+		data, _ := v.([]byte)
+		ctx.SetResponseMessage(NewMessage(408, data))
+	})
+	var copied Context
+	var handledErr error
+	rt.setErrorHandler(func(ctx Context, err error) { handledErr = err })
+	slowHandler := func(ctx Context) {
+		_, hasDeadline := ctx.Deadline()
+		if !hasDeadline {
+			ctx.SetResponseMessage(NewMessage("no deadline", nil))
+			return
+		}
+		copied = ctx.Copy()
+		ctx.Set("progress", []byte("started"))
+		select {
+		case <-ctx.Done():
+			ctx.SetResponseMessage(NewMessage("late", nil))
+			ctx.SetHandlerError(ctx.Err())
+		case <-time.After(time.Millisecond * 50):
+			ctx.SetResponseMessage(NewMessage("in time", nil))
+		}
+	}
+	rt.register(1, slowHandler)
+	rt.register(2, slowHandler)
+	rt.register(3, slowHandler)
+	rt.registerRange(10, 20, nil, slowHandler)
+
+	t.Run("when handler times out", func(t *testing.T) {
+		for _, id := range []int{1, 10} {
+			sess := newSession(nil, &sessionOption{respQueueSize: 10})
+			ctx := newTestContext(sess, NewMessage(id, nil))
+			rt.handleRequest(ctx)
+			assert.Nil(t, ctx.Response()) // late response is dropped
+			assert.NoError(t, handledErr)
+			assert.NoError(t, ctx.Err()) // deadline removed
+			assert.False(t, copied.Send())
+
+			timeoutCtx := <-sess.respStream
+			assert.EqualValues(t, 408, timeoutCtx.Response().ID())
+			assert.Equal(t, "started", string(timeoutCtx.Response().Data())) // set by the handler before the deadline
+			assert.Equal(t, id, timeoutCtx.Request().ID())
+			assert.Empty(t, sess.respStream)
+		}
+	})
+	t.Run("when handler returns in time", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{respQueueSize: 10})
+		ctx := newTestContext(sess, NewMessage(2, nil))
+		rt.handleRequest(ctx)
+		assert.Equal(t, "in time", ctx.Response().ID())
+		_, hasDeadline := ctx.Deadline()
+		assert.False(t, hasDeadline)
+		assert.Empty(t, sess.respStream)
+
+		// the copy made in the handler keeps working after it returns
+		_, hasDeadline = copied.Deadline()
+		assert.False(t, hasDeadline)
+		assert.NoError(t, copied.Err())
+		assert.True(t, copied.SetResponseMessage(NewMessage("copied", nil)).Send())
+		assert.Equal(t, "copied", (<-sess.respStream).Response().ID())
+	})
+	t.Run("when route has no timeout", func(t *testing.T) {
+		ctx := newTestContext(nil, NewMessage(3, nil))
+		rt.handleRequest(ctx)
+		assert.Equal(t, "no deadline", ctx.Response().ID())
+	})
+	t.Run("when there's no timeout handler", func(t *testing.T) {
+		rt.setTimeoutHandler(nil)
+		sess := newSession(nil, &sessionOption{respQueueSize: 10})
+		ctx := newTestContext(sess, NewMessage(1, nil))
+		rt.handleRequest(ctx)
+		assert.Nil(t, ctx.Response())
+		assert.Empty(t, sess.respStream)
+	})
+}
+
+func TestRouter_handleReq_timeoutIgnored(t *testing.T) {
+	rt := newRouter()
+	rt.setDefaultTimeout(time.Millisecond * 10)
+	rt.setTimeoutHandler(func(ctx Context) {
+		ctx.SetResponseMessage(NewMessage(408, nil))
+	})
+	release := make(chan struct{})
+	rt.register(1, func(ctx Context) {
+		<-release // ignores ctx.Done()
+		ctx.SetResponseMessage(NewMessage("late", nil))
+	})
+
+	sess := newSession(nil, &sessionOption{respQueueSize: 10})
+	ctx := newTestContext(sess, NewMessage(1, nil))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		rt.handleRequest(ctx)
+	}()
+
+	// the timeout response is sent at the deadline, while the handler's still running
+	select {
+	case timeoutCtx := <-sess.respStream:
+		assert.EqualValues(t, 408, timeoutCtx.Response().ID())
+	case <-time.After(time.Second):
+		assert.Fail(t, "timeout response is not sent at the deadline")
+	}
+	close(release)
+	<-done
+	assert.Nil(t, ctx.Response())
+	assert.Empty(t, sess.respStream)
+}
+
+func TestRouter_handleReq_timeoutStream(t *testing.T) {
+	rt := newRouter()
+	rt.setDefaultTimeout(time.Millisecond * 10)
+	var st Stream
+	rt.register(1, func(ctx Context) { st = ctx.Stream() })
+	rt.register(2, func(ctx Context) {
+		st = ctx.Stream()
+		<-ctx.Done()
+	})
+
+	t.Run("when handler returns in time", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{respQueueSize: 10})
+		rt.handleRequest(newTestContext(sess, NewMessage(1, nil)))
+		time.Sleep(time.Millisecond * 20) // after the deadline
+		assert.NoError(t, st.SendMessage(NewMessage(3, nil)))
+		assert.NoError(t, st.End())
+		assert.Len(t, sess.respStream, 2)
+	})
+	t.Run("when handler times out", func(t *testing.T) {
+		sess := newSession(nil, &sessionOption{respQueueSize: 10})
+		rt.handleRequest(newTestContext(sess, NewMessage(2, nil)))
+		assert.ErrorIs(t, st.SendMessage(NewMessage(3, nil)), context.DeadlineExceeded)
+		assert.Empty(t, sess.respStream)
+	})
+}
+
+func TestServer_HandlerTimeout(t *testing.T) {
+	s := NewServer(&ServerOption{HandlerTimeout: time.Second})
+	s.SetRouteTimeout(uint8(1), time.Minute)
+	s.TimeoutHandler(nilHandler)
+	tb := s.router.routes()
+	assert.Equal(t, time.Second, tb.handlerTimeout)
+	assert.Equal(t, time.Minute, tb.timeoutOf(1))
+	assert.NotNil(t, tb.timeoutHandler)
+}
+
+func TestTCPSession_Send_canceled(t *testing.T) {
+	sess := newSession(nil, &sessionOption{respQueueSize: 10})
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx := sess.AllocateContext().SetResponseMessage(NewMessage(1, nil))
+	ctx.WithContext(c)
+	assert.False(t, sess.Send(ctx))
+	assert.Empty(t, sess.respStream)
+}
@@ -0,0 +1,91 @@
+package easytcp
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// HandshakeFunc negotiates the protocol version with the client in a handshake route.
+// It returns the version agreed, which is stored in the session.
+// It can set the response of ctx to tell the client the version.
+type HandshakeFunc func(ctx Context) (version int, err error)
+
+// versionedRoute routes the messages of an ID when the session's protocol version is in [minVersion, maxVersion].
+type versionedRoute struct {
+	minVersion, maxVersion int
+	handler                HandlerFunc
+	groupMiddlewares       []MiddlewareFunc
+	middlewares            []MiddlewareFunc
+}
+
+// ProtocolVersion returns the protocol version negotiated, 0 means not negotiated.
+func (s *session) ProtocolVersion() int {
+	return int(atomic.LoadInt64(&s.protocolVersion))
+}
+
+// SetProtocolVersion sets the protocol version negotiated.
+func (s *session) SetProtocolVersion(version int) {
+	atomic.StoreInt64(&s.protocolVersion, int64(version))
+}
+
+// handshakeHandler returns a HandlerFunc which stores the version returned from handshake in the session.
+func handshakeHandler(handshake HandshakeFunc) HandlerFunc {
+	if handshake == nil {
+		return nil
+	}
+	return func(ctx Context) {
+		version, err := handshake(ctx)
+		if err != nil {
+			ctx.SetHandlerError(err)
+			return
+		}
+		ctx.Session().SetProtocolVersion(version)
+	}
+}
+
+// registerVersioned stores handler and middlewares for id, when the protocol version is in [minVersion, maxVersion].
+// The route of the same version range is replaced.
+// Panics if the version range is invalid or overlaps with a registered one,
+// or id conflicts with a registered one of another type.
+func (r *Router) registerVersioned(id interface{}, minVersion, maxVersion int, groupMiddlewares []MiddlewareFunc, h HandlerFunc, m ...MiddlewareFunc) {
+	if minVersion > maxVersion {
+		panic(fmt.Sprintf("easytcp: invalid protocol version range [%d, %d]", minVersion, maxVersion))
+	}
+	if h == nil {
+		return
+	}
+	rawID, id := id, normalizeID(id)
+	vr := &versionedRoute{
+		minVersion:       minVersion,
+		maxVersion:       maxVersion,
+		handler:          h,
+		groupMiddlewares: compactMiddlewares(groupMiddlewares),
+		middlewares:      compactMiddlewares(m),
+	}
+	r.update(func(t *routeTable) {
+		t.setRawID(id, rawID)
+		routes := make([]*versionedRoute, 0, len(t.versionedRoutes[id])+1)
+		for _, v := range t.versionedRoutes[id] {
+			if v.minVersion == minVersion && v.maxVersion == maxVersion {
+				continue // replaced
+			}
+			if v.minVersion <= maxVersion && minVersion <= v.maxVersion {
+				panic(fmt.Sprintf("easytcp: protocol version range [%d, %d] of message ID %v overlaps with [%d, %d]",
+					minVersion, maxVersion, rawID, v.minVersion, v.maxVersion))
+			}
+			routes = append(routes, v)
+		}
+		t.versionedRoutes[id] = append(routes, vr)
+	})
+}
+
+// matchVersion returns the index of the versioned route of id, whose version range contains version.
+// Returns -1 if there's no match.
+func (t *routeTable) matchVersion(id interface{}, version int) int {
+	for i, vr := range t.versionedRoutes[id] {
+		if version >= vr.minVersion && version <= vr.maxVersion {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,110 @@
+package easytcp
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTCPSession_ProtocolVersion(t *testing.T) {
+	sess := newSession(nil, &sessionOption{})
+	assert.Zero(t, sess.ProtocolVersion())
+	sess.SetProtocolVersion(3)
+	assert.Equal(t, 3, sess.ProtocolVersion())
+}
+
+func TestRouter_registerVersioned(t *testing.T) {
+	rt := newRouter()
+	assert.Panics(t, func() { rt.registerVersioned(1, 2, 1, nil, nilHandler) })
+
+	rt.registerVersioned(1, 1, 2, nil, nil)
+	assert.Empty(t, rt.routes().versionedRoutes)
+
+	rt.registerVersioned(1, 1, 2, nil, nilHandler)
+	rt.registerVersioned(1, 3, 4, nil, nilHandler)
+	assert.Len(t, rt.routes().versionedRoutes[1], 2)
+}
+
+func TestRouter_registerVersioned_conflicts(t *testing.T) {
+	rt := newRouter()
+	rt.registerVersioned(1, 1, 2, nil, nilHandler)
+	assert.Panics(t, func() { rt.registerVersioned(1, 2, 3, nil, nilHandler) })
+	assert.Panics(t, func() { rt.registerVersioned(uint8(1), 5, 6, nil, nilHandler) })
+
+	rt.registerVersioned(1, 1, 2, nil, nilHandler) // replaced
+	assert.Len(t, rt.routes().versionedRoutes[1], 1)
+}
+
+func TestServer_AddVersionedRoute(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	var result []string
+	newHandler := func(name string) HandlerFunc {
+		return func(ctx Context) { result = append(result, name) }
+	}
+	group := s.Group(func(next HandlerFunc) HandlerFunc {
+		return func(ctx Context) {
+			result = append(result, "group")
+			next(ctx)
+		}
+	})
+	s.AddRoute(1, newHandler("default"))
+	s.AddVersionedRoute(1, 1, 2, newHandler("v1"))
+	group.AddVersionedRoute(1, 3, 5, newHandler("v3"))
+	s.AddVersionedRoute(2, 1, 1, newHandler("only v1"))
+	s.NotFoundHandler(newHandler("not found"))
+
+	cases := []struct {
+		id      int
+		version int
+		expect  []string
+	}{
+		{1, 0, []string{"default"}},
+		{1, 2, []string{"v1"}},
+		{1, 4, []string{"group", "v3"}},
+		{1, 6, []string{"default"}},
+		{2, 1, []string{"only v1"}},
+		{2, 2, []string{"not found"}},
+	}
+	for _, c := range cases {
+		result = nil
+		sess := newSession(nil, &sessionOption{})
+		sess.SetProtocolVersion(c.version)
+		s.router.handleRequest(newTestContext(sess, NewMessage(c.id, nil)))
+		assert.Equal(t, c.expect, result, "id %d, version %d", c.id, c.version)
+	}
+
+	routes := s.Routes()
+	assert.Len(t, routes, 4)
+	assert.Equal(t, []int{1, 2}, routes[0].Versions)
+	assert.Equal(t, []int{3, 5}, routes[1].Versions)
+	assert.Nil(t, routes[2].Versions)
+	assert.Equal(t, 2, routes[3].ID)
+
+	s.RemoveRoute(1)
+	assert.Len(t, s.Routes(), 1)
+}
+
+func TestServer_AddHandshakeRoute(t *testing.T) {
+	s := NewServer(&ServerOption{})
+	var handledErr error
+	s.ErrorHandler(func(ctx Context, err error) { handledErr = err })
+	s.AddHandshakeRoute(1, func(ctx Context) (int, error) {
+		if len(ctx.Request().Data()) == 0 {
+			return 0, fmt.Errorf("no version")
+		}
+		ctx.SetResponseMessage(NewMessage(1, ctx.Request().Data()))
+		return int(ctx.Request().Data()[0]), nil
+	})
+	s.AddHandshakeRoute(2, nil)
+	assert.Len(t, s.Routes(), 1)
+
+	sess := newSession(nil, &sessionOption{})
+	s.router.handleRequest(newTestContext(sess, NewMessage(1, nil)))
+	assert.EqualError(t, handledErr, "no version")
+	assert.Zero(t, sess.ProtocolVersion())
+
+	ctx := newTestContext(sess, NewMessage(1, []byte{2}))
+	s.router.handleRequest(ctx)
+	assert.Equal(t, 2, sess.ProtocolVersion())
+	assert.Equal(t, []byte{2}, ctx.Response().Data())
+}
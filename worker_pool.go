@@ -0,0 +1,90 @@
+package easytcp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// WorkerPoolStats is the statistics of the worker pool which handles the messages.
+type WorkerPoolStats struct {
+	Workers   int // number of workers.
+	Busy      int // number of workers handling a message.
+	QueueSize int // capacity of the task queue.
+	QueueLen  int // number of tasks waiting in the queue.
+}
+
+// workerPool runs tasks in a fixed number of goroutines.
+type workerPool struct {
+	size     int
+	tasks    chan func()
+	busy     int64
+	stoppedC chan struct{}
+	stopOnce sync.Once
+}
+
+// newWorkerPool creates a workerPool with size workers and a task queue of queueSize.
+// Workers won't run until start is called.
+func newWorkerPool(size, queueSize int) *workerPool {
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &workerPool{
+		size:     size,
+		tasks:    make(chan func(), queueSize),
+		stoppedC: make(chan struct{}),
+	}
+}
+
+// start spawns the workers.
+func (p *workerPool) start() {
+	for i := 0; i < p.size; i++ {
+		go p.work()
+	}
+}
+
+// work runs tasks in a loop, until the pool is stopped.
+func (p *workerPool) work() {
+	for {
+		select {
+		case <-p.stoppedC:
+			return
+		case task := <-p.tasks:
+			atomic.AddInt64(&p.busy, 1)
+			task()
+			atomic.AddInt64(&p.busy, -1)
+		}
+	}
+}
+
+// submit puts task into the queue, blocks when the queue is full.
+// Returns false if cancelC is closed or the pool is stopped before the task's queued.
+func (p *workerPool) submit(task func(), cancelC <-chan struct{}) bool {
+	select {
+	case <-p.stoppedC:
+		return false
+	default:
+	}
+	select {
+	case <-cancelC:
+		return false
+	case <-p.stoppedC:
+		return false
+	case p.tasks <- task:
+		return true
+	}
+}
+
+// stop stops the workers, tasks left in the queue will be discarded.
+func (p *workerPool) stop() {
+	p.stopOnce.Do(func() { close(p.stoppedC) })
+}
+
+// stats returns the statistics of the pool.
+func (p *workerPool) stats() WorkerPoolStats {
+	return WorkerPoolStats{
+		Workers:   p.size,
+		Busy:      int(atomic.LoadInt64(&p.busy)),
+		QueueSize: cap(p.tasks),
+		QueueLen:  len(p.tasks),
+	}
+}
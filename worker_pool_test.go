@@ -0,0 +1,76 @@
+package easytcp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_newWorkerPool(t *testing.T) {
+	p := newWorkerPool(2, -1)
+	assert.Equal(t, 2, p.size)
+	assert.Equal(t, 0, cap(p.tasks))
+
+	p = newWorkerPool(2, 10)
+	assert.Equal(t, WorkerPoolStats{Workers: 2, QueueSize: 10}, p.stats())
+}
+
+func Test_workerPool_submit(t *testing.T) {
+	t.Run("when workers are running", func(t *testing.T) {
+		p := newWorkerPool(2, 10)
+		p.start()
+		defer p.stop()
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			assert.True(t, p.submit(wg.Done, nil))
+		}
+		wg.Wait()
+	})
+	t.Run("when queue is full", func(t *testing.T) {
+		p := newWorkerPool(1, 1)
+		p.start()
+		defer p.stop()
+
+		release := make(chan struct{})
+		running := make(chan struct{})
+		assert.True(t, p.submit(func() {
+			close(running)
+			<-release
+		}, nil))
+		<-running
+		assert.True(t, p.submit(func() {}, nil)) // queued
+		assert.Equal(t, WorkerPoolStats{Workers: 1, Busy: 1, QueueSize: 1, QueueLen: 1}, p.stats())
+
+		// blocks until canceled
+		cancelC := make(chan struct{})
+		go func() {
+			time.Sleep(time.Millisecond * 5)
+			close(cancelC)
+		}()
+		assert.False(t, p.submit(func() {}, cancelC))
+		close(release)
+	})
+	t.Run("when pool is stopped", func(t *testing.T) {
+		p := newWorkerPool(1, 1)
+		p.stop()
+		p.stop() // stop twice is safe
+		assert.False(t, p.submit(func() {}, nil))
+	})
+}
+
+func TestTCPSession_dispatch(t *testing.T) {
+	r := newRouter()
+	handled := make(chan struct{})
+	r.register(1, func(ctx Context) { close(handled) })
+
+	p := newWorkerPool(1, 1)
+	p.start()
+	defer p.stop()
+
+	sess := newSession(nil, &sessionOption{asyncRouter: true, pool: p, respQueueSize: 10})
+	sess.dispatch(r, NewMessage(1, []byte("test")))
+	<-handled
+}